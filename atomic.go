@@ -0,0 +1,139 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is a temporary File that replaces a named file when it is
+// committed. Readers of the named file see either its old contents or
+// the complete new contents, never a partial write.
+//
+// Closing an AtomicFile without calling Commit discards it.
+// A typical use is:
+//
+//	af, err := filer.CreateAtomic(name, 0644)
+//	if err != nil {
+//		return err
+//	}
+//	defer af.Close()
+//	if _, err := af.Write(data); err != nil {
+//		return err
+//	}
+//	return af.Commit()
+type AtomicFile struct {
+	*File
+
+	// SyncDir, if true, makes Commit sync the directory holding the
+	// file after renaming, so the rename survives a system crash.
+	SyncDir bool
+
+	name      string
+	committed bool
+}
+
+// CreateAtomic creates an AtomicFile that replaces name on Commit.
+// The contents are written to a temporary file in the same directory
+// as name, created with mode perm (before umask).
+//
+// Like TempFile, it blocks if the Filer has exhausted its file
+// descriptors until one is available.
+func (f *Filer) CreateAtomic(name string, perm os.FileMode) (*AtomicFile, error) {
+	af, err := f.createAtomic(context.Background(), name, perm)
+	if af != nil {
+		f.setCreator(&af.File.origin)
+	}
+	return af, err
+}
+
+// CreateAtomicContext is like CreateAtomic, but gives up waiting for a
+// file descriptor when ctx is done, returning ctx.Err().
+func (f *Filer) CreateAtomicContext(ctx context.Context, name string, perm os.FileMode) (*AtomicFile, error) {
+	af, err := f.createAtomic(ctx, name, perm)
+	if af != nil {
+		f.setCreator(&af.File.origin)
+	}
+	return af, err
+}
+
+func (f *Filer) createAtomic(ctx context.Context, name string, perm os.FileMode) (*AtomicFile, error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := f.namedTempFile(ctx, true, dir, "."+base+".", ".tmp", perm)
+	if err != nil {
+		return nil, err
+	}
+	file.atomicFile = true
+	return &AtomicFile{File: file, name: name}, nil
+}
+
+// Commit syncs the contents of the file to stable storage, renames it
+// over the target name, and closes it.
+//
+// If Commit fails before the rename, the target is unchanged and the
+// AtomicFile remains open to be closed.
+func (af *AtomicFile) Commit() error {
+	if af.committed {
+		return &os.PathError{Op: "commit", Path: af.name, Err: os.ErrClosed}
+	}
+	if err := af.File.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(af.File.Name(), af.name); err != nil {
+		return err
+	}
+	af.committed = true
+
+	f := af.File.filer
+	f.mu.Lock()
+	af.File.isTemp = false // keep the renamed file on Close
+	f.mu.Unlock()
+	if err := af.File.Close(); err != nil {
+		return err
+	}
+	if af.SyncDir {
+		return f.syncDir(filepath.Dir(af.name))
+	}
+	return nil
+}
+
+// Close closes the file. If it has not been committed, the temporary
+// file is removed and the target is unchanged. Close after a successful
+// Commit does nothing.
+func (af *AtomicFile) Close() error {
+	if af.committed {
+		return nil
+	}
+	return af.File.Close()
+}
+
+// syncDir syncs the directory dir, using a descriptor from the Filer.
+func (f *Filer) syncDir(dir string) error {
+	d, err := f.openOS(context.Background(), true, dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	f.release()
+	return err
+}
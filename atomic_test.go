@@ -0,0 +1,86 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilerCreateAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "target")
+	if err := os.WriteFile(name, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filer := NewFiler(1)
+
+	af, err := filer.CreateAtomic(name, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := af.Write([]byte("discarded")); err != nil {
+		t.Fatal(err)
+	}
+	if err := af.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != "old" {
+		t.Errorf("after Close without Commit, target=%q, %v, want old", b, err)
+	}
+
+	af, err = filer.CreateAtomic(name, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer af.Close()
+	af.SyncDir = true
+	if _, err := filer.TryOpen(name); err != ErrNoDescriptors {
+		t.Errorf("TryOpen while AtomicFile open err=%v, want ErrNoDescriptors", err)
+	}
+	if _, err := af.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != "old" {
+		t.Errorf("before Commit, target=%q, %v, want old", b, err)
+	}
+	if err := af.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := af.Commit(); err == nil {
+		t.Error("second Commit succeeded")
+	}
+	if err := af.Close(); err != nil {
+		t.Errorf("Close after Commit: %v", err)
+	}
+
+	if b, err := os.ReadFile(name); err != nil || string(b) != "new" {
+		t.Errorf("after Commit, target=%q, %v, want new", b, err)
+	}
+	if fi, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	} else if perm := fi.Mode().Perm(); perm&^0640 != 0 {
+		t.Errorf("target perm=%v, want subset of 0640", perm)
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the target", len(entries))
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Commit, want 0", open)
+	}
+}
@@ -38,7 +38,12 @@ func (f *Filer) BufferFile(memSize int) *BufferFile {
 		filer:  f,
 		bufMax: memSize,
 	}
-	bf.pcN = runtime.Callers(0, bf.pc[:])
+	if f.StackDepth > 0 {
+		bf.stk = make([]uintptr, f.StackDepth+2)
+		bf.stk = bf.stk[:runtime.Callers(0, bf.stk)]
+	} else {
+		bf.pcN = runtime.Callers(0, bf.pc[:])
+	}
 	return bf
 }
 
@@ -67,14 +72,19 @@ type BufferFile struct {
 	// caller stack at creation
 	pc  [3]uintptr
 	pcN int
+	stk []uintptr // replaces pc if Filer.StackDepth is set
 }
 
 func (bf *BufferFile) ensureFile() error {
 	if bf.f == nil {
 		bf.f, bf.err = bf.filer.TempFile("", "bufferfile-", "")
 		if bf.f != nil {
+			bf.filer.mu.Lock()
 			bf.f.pcN = bf.pcN
 			bf.f.pc = bf.pc
+			bf.f.stk = bf.stk
+			bf.f.bufferFile = true
+			bf.filer.mu.Unlock()
 		}
 	}
 	return bf.err
@@ -0,0 +1,111 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// tempPID matches the pid in names made by tempName: the ".iox-<pid>-"
+// marker followed by the default random part of 16 hex digits.
+var tempPID = regexp.MustCompile(`\.iox-([0-9]+)-[0-9a-f]{16}`)
+
+// CleanTempdir removes temporary files left in the Filer's temporary
+// directories by processes that exited without closing them, such as
+// the backing files of BufferFiles after a crash. It reports the number
+// of files removed.
+//
+// Only files named by a Filer, last modified more than olderThan ago,
+// whose creating process is no longer running are removed. Files named
+// using a custom TempName are not recognized.
+func (f *Filer) CleanTempdir(ctx context.Context, olderThan time.Duration) (int, error) {
+	removed := 0
+	cutoff := time.Now().Add(-olderThan)
+	for _, td := range f.tempdirs {
+		n, err := f.cleanDir(ctx, td.path, cutoff)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (f *Filer) cleanDir(ctx context.Context, path string, cutoff time.Time) (int, error) {
+	dir, err := f.openOS(ctx, true, path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	f.release()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		m := tempPID.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		pid, err := strconv.Atoi(m[1])
+		if err != nil || pid == os.Getpid() || pidAlive(pid) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(path, entry.Name())); err != nil {
+			if os.IsNotExist(err) {
+				continue // removed by another sweep
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// cleanTempdir is the background sweep started by CleanTempdirAge.
+func (f *Filer) cleanTempdir() {
+	n, err := f.CleanTempdir(context.Background(), f.CleanTempdirAge)
+	if f.Logf != nil {
+		if err != nil {
+			f.Logf("iox.Filer.CleanTempdir: %v", err)
+		} else if n > 0 {
+			f.Logf("iox.Filer.CleanTempdir: removed %d orphaned files", n)
+		}
+	}
+}
+
+// pidAlive reports whether the process pid is running.
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
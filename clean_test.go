@@ -0,0 +1,90 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilerCleanTempdir(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	deadPID := cmd.Process.Pid
+
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	create := func(name string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("leftover"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	orphan := create(fmt.Sprintf("bufferfile.iox-%d-0123456789abcdef", deadPID), old)
+	keep := []string{
+		create(fmt.Sprintf("young.iox-%d-0123456789abcdef", deadPID), time.Now()),
+		create(fmt.Sprintf("alive.iox-%d-0123456789abcdef", os.Getppid()), old),
+		create(fmt.Sprintf("self.iox-%d-0123456789abcdef", os.Getpid()), old),
+		create("unrelated", old),
+		create(fmt.Sprintf("radiox%d_final.wav", deadPID), old),
+		create(fmt.Sprintf("custom.iox-%d-final.wav", deadPID), old),
+	}
+
+	filer := NewFiler(1)
+	filer.SetTempdir(dir)
+	n, err := filer.CleanTempdir(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("CleanTempdir removed %d files, want 1", n)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan not removed: %v", err)
+	}
+	for _, path := range keep {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("CleanTempdir removed %s: %v", filepath.Base(path), err)
+		}
+	}
+
+	orphan = create(fmt.Sprintf("tmp.iox-%d-fedcba9876543210.wav", deadPID), old)
+	filer = NewFiler(1)
+	filer.SetTempdir(dir)
+	filer.CleanTempdirAge = time.Hour
+	f, err := filer.TempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(orphan); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("CleanTempdirAge did not remove orphan")
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DebugHandler returns an http.Handler that reports the files and
+// connections held open by the Filer, which is useful for tracking
+// down descriptor hogs on a live server.
+//
+// The report is plain text unless the request has the query parameter
+// format=json. The report names the function that created each file,
+// add the query parameter stack=1 to include the recorded creator stack.
+//
+// A BufferFile that fits in memory holds no file descriptor and is not
+// listed. One that has spilled to disk is listed with kind "bufferfile".
+func DebugHandler(f *Filer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		withStack := r.FormValue("stack") != ""
+		report := f.debugReport(withStack)
+		if r.FormValue("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			enc.Encode(report)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "iox.Filer: %d open, limit %d, %d waiting\n\n", report.Open, report.Limit, report.Waiting)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "KIND\tAGE\tNAME\tCREATOR\n")
+		for _, file := range report.Files {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", file.Kind, file.Age.Round(time.Millisecond), file.Name, file.Creator)
+		}
+		tw.Flush()
+		if withStack {
+			for _, file := range report.Files {
+				fmt.Fprintf(w, "\n%s %s:\n\t%s\n", file.Kind, file.Name, strings.Join(file.Stack, "\n\t"))
+			}
+		}
+	})
+}
+
+type debugReport struct {
+	Open    int         `json:"open"`
+	Limit   int         `json:"limit"`
+	Waiting int         `json:"waiting"`
+	Files   []debugFile `json:"files"`
+}
+
+type debugFile struct {
+	Name    string        `json:"name"`
+	Kind    string        `json:"kind"` // "file", "dir", "pipe", "temp", "bufferfile", "conn", or "listener"
+	Created time.Time     `json:"created"`
+	Age     time.Duration `json:"age"` // nanoseconds in JSON
+	Creator string        `json:"creator"`
+	Stack   []string      `json:"stack,omitempty"`
+}
+
+// debugReport reports the descriptors held by f, including their
+// creator stacks if withStack is set.
+func (f *Filer) debugReport(withStack bool) debugReport {
+	now := time.Now()
+
+	// Symbolizing stacks is slow, so copy the origins under f.mu
+	// and symbolize them after.
+	f.mu.Lock()
+	report := debugReport{
+		Open:    f.used,
+		Limit:   f.limitLocked(),
+		Waiting: f.stats.Waiting,
+		Files:   make([]debugFile, 0, len(f.files)+len(f.socks)),
+	}
+	origins := make([]origin, 0, cap(report.Files))
+	for key := range f.files {
+		file := key.value()
+		if file == nil {
+			continue
+		}
+		report.Files = append(report.Files, debugFile{
+			Name: file.File.Name(),
+			Kind: file.kind(),
+		})
+		origins = append(origins, file.origin)
+	}
+	for s := range f.socks {
+		report.Files = append(report.Files, debugFile{
+			Name: s.name,
+			Kind: s.kind,
+		})
+		origins = append(origins, s.origin)
+	}
+	f.mu.Unlock()
+
+	for i := range report.Files {
+		o := &origins[i]
+		file := &report.Files[i]
+		file.Created = o.created
+		file.Age = now.Sub(o.created)
+		file.Creator = o.creator()
+		if withStack {
+			file.Stack = o.stack()
+		}
+	}
+
+	// Oldest first.
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Created.Before(report.Files[j].Created)
+	})
+	return report
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func openDebugFile(filer *Filer) (*File, error) { return filer.TempFile("", "debugfile-", "") }
+
+func TestDebugHandler(t *testing.T) {
+	filer := NewFiler(0)
+	f1, err := openDebugFile(filer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	bf := filer.BufferFile(1)
+	defer bf.Close()
+	if _, err := bf.Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(DebugHandler(filer))
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL + "?stack=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(b)
+	if !strings.Contains(text, "2 open") {
+		t.Errorf("text report does not count 2 open files:\n%s", text)
+	}
+	if !strings.Contains(text, f1.Name()) || !strings.Contains(text, "iox.openDebugFile") {
+		t.Errorf("text report does not list %s created by openDebugFile:\n%s", f1.Name(), text)
+	}
+	if !strings.Contains(text, "debug_test.go:") {
+		t.Errorf("text report does not include the creator stack:\n%s", text)
+	}
+
+	res, err = srv.Client().Get(srv.URL + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report debugReport
+	err = json.NewDecoder(res.Body).Decode(&report)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 2 {
+		t.Fatalf("JSON report has %d files, want 2", len(report.Files))
+	}
+	if got := report.Files[0]; got.Kind != "temp" || got.Name != f1.Name() || !strings.HasSuffix(got.Creator, "iox.openDebugFile") {
+		t.Errorf("report.Files[0]=%+v, want temp file %s created by openDebugFile", got, f1.Name())
+	}
+	if got := report.Files[1]; got.Kind != "bufferfile" || !strings.HasSuffix(got.Creator, "iox.TestDebugHandler") {
+		t.Errorf("report.Files[1]=%+v, want bufferfile created by TestDebugHandler", got)
+	}
+}
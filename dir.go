@@ -0,0 +1,229 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// OpenDir opens the named directory for reading its entries.
+//
+// It is similar to os.Open on a directory except it will block if Filer
+// has exhausted its file descriptors until one is available.
+// It fails if name is not a directory.
+func (f *Filer) OpenDir(name string) (*File, error) {
+	file, err := f.openDir(context.Background(), name)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// OpenDirContext is like OpenDir, but gives up waiting for a file
+// descriptor when ctx is done, returning ctx.Err().
+func (f *Filer) OpenDirContext(ctx context.Context, name string) (*File, error) {
+	file, err := f.openDir(ctx, name)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+func (f *Filer) openDir(ctx context.Context, name string) (*File, error) {
+	return f.openFile(ctx, true, name, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+}
+
+// ReadDir reads the named directory, returning its entries sorted by
+// filename, like os.ReadDir. It holds a file descriptor of the Filer
+// while reading.
+func (f *Filer) ReadDir(name string) ([]os.DirEntry, error) {
+	return f.readDir(context.Background(), name)
+}
+
+func (f *Filer) readDir(ctx context.Context, name string) ([]os.DirEntry, error) {
+	dir, err := f.openDir(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, err
+}
+
+// WalkDir walks the file tree rooted at root, calling fn for each file
+// or directory in the tree, including root, like filepath.WalkDir.
+// Directories are read with ReadDir, so the walk holds at most one file
+// descriptor of the Filer at a time.
+func (f *Filer) WalkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = f.walkDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (f *Filer) walkDir(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil // successfully skipped directory
+		}
+		return err
+	}
+
+	entries, err := f.ReadDir(path)
+	if err != nil {
+		// Second call, to report ReadDir error.
+		err = fn(path, d, err)
+		if err != nil {
+			if err == filepath.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, d1 := range entries {
+		path1 := filepath.Join(path, d1.Name())
+		if err := f.walkDir(path1, d1, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkDirParallel is like WalkDir, but reads up to n directories at
+// once, never holding more than n directory descriptors. It walks with
+// at most n goroutines, including that of the caller.
+//
+// The function fn is called concurrently from multiple goroutines,
+// and the walk is not in lexical order. A directory is read only after
+// fn has been called for it. The walk stops at the first error returned
+// by fn, or when ctx is done.
+func (f *Filer) WalkDirParallel(ctx context.Context, root string, n int, fn fs.WalkDirFunc) error {
+	if n <= 0 {
+		panic("iox.Filer.WalkDirParallel: n must be positive")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &walker{
+		f:       f,
+		ctx:     ctx,
+		cancel:  cancel,
+		fn:      fn,
+		workers: make(chan struct{}, n-1),
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		w.call(root, nil, err)
+	} else {
+		w.walk(root, fs.FileInfoToDirEntry(info))
+	}
+	w.wg.Wait()
+
+	switch w.err {
+	case nil:
+		return ctx.Err()
+	case filepath.SkipAll:
+		return nil
+	}
+	return w.err
+}
+
+// walker is the state of a WalkDirParallel.
+type walker struct {
+	f      *Filer
+	ctx    context.Context
+	cancel context.CancelFunc
+	fn     fs.WalkDirFunc
+
+	// workers is held by each goroutine walking besides the caller,
+	// each reading one directory at a time.
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // first error, stops the walk
+}
+
+// call calls w.fn, reporting whether to descend into a directory or
+// continue with the remaining files of a directory.
+func (w *walker) call(path string, d fs.DirEntry, err error) bool {
+	if w.ctx.Err() != nil {
+		return false
+	}
+	err = w.fn(path, d, err)
+	if err == nil {
+		return true
+	}
+	if err != filepath.SkipDir {
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.mu.Unlock()
+		w.cancel()
+	}
+	return false
+}
+
+func (w *walker) walk(path string, d fs.DirEntry) {
+	if !w.call(path, d, nil) || !d.IsDir() {
+		return
+	}
+
+	entries, err := w.f.readDir(w.ctx, path)
+	if err != nil {
+		if w.ctx.Err() != nil || !w.call(path, d, err) {
+			return
+		}
+	}
+
+	for _, d1 := range entries {
+		path1 := filepath.Join(path, d1.Name())
+		if d1.IsDir() {
+			select {
+			case w.workers <- struct{}{}:
+				w.wg.Add(1)
+				go func() {
+					defer w.wg.Done()
+					w.walk(path1, d1)
+					<-w.workers
+				}()
+			default:
+				w.walk(path1, d1) // all busy, walk it here
+			}
+			continue
+		}
+		if !w.call(path1, d1, nil) {
+			return // SkipDir skips the remaining files, or the walk stopped
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilerDir(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a/x/y", "a/z", "b", "c/d/e/f"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"1", "a/2", "a/x/3", "a/x/y/4", "b/5", "b/6", "c/d/e/f/7"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == "x" {
+			return filepath.SkipDir
+		}
+		want = append(want, path)
+		return nil
+	})
+
+	filer := NewFiler(100)
+	if _, err := filer.OpenDir(filepath.Join(root, "1")); err == nil {
+		t.Error("OpenDir of a file succeeded")
+	}
+	entries, err := filer.ReadDir(filepath.Join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"2", "x", "z"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir=%v, want %v", names, want)
+	}
+
+	var got []string
+	err = filer.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == "x" {
+			return filepath.SkipDir
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir visited\n%v\nwant\n%v", got, want)
+	}
+
+	filer = NewFiler(100)
+	var mu sync.Mutex
+	got = nil
+	err = filer.WalkDirParallel(context.Background(), root, 2, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == "x" {
+			return filepath.SkipDir
+		}
+		mu.Lock()
+		got = append(got, path)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDirParallel visited\n%v\nwant\n%v", got, want)
+	}
+	if stats := filer.Stats(); stats.PeakOpen > 2 || stats.Open != 0 {
+		t.Errorf("WalkDirParallel PeakOpen=%d, Open=%d, want at most 2, 0", stats.PeakOpen, stats.Open)
+	}
+
+	errStop := fs.ErrInvalid
+	err = filer.WalkDirParallel(context.Background(), root, 2, func(path string, d fs.DirEntry, err error) error {
+		if d.Name() == "e" {
+			return errStop
+		}
+		return err
+	})
+	if err != errStop {
+		t.Errorf("WalkDirParallel err=%v, want %v", err, errStop)
+	}
+}
+
+func TestFilerWalkDirParallelWide(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 200; i++ {
+		if err := os.Mkdir(filepath.Join(root, fmt.Sprintf("d%d", i)), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filer := NewFiler(100)
+	base := runtime.NumGoroutine()
+	var mu sync.Mutex
+	peak, dirs := 0, 0
+	err := filer.WalkDirParallel(context.Background(), root, 4, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		time.Sleep(100 * time.Microsecond) // let the walkers pile up
+		mu.Lock()
+		dirs++
+		if n := runtime.NumGoroutine() - base; n > peak {
+			peak = n
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirs != 201 {
+		t.Errorf("WalkDirParallel visited %d directories, want 201", dirs)
+	}
+	if peak > 10 { // 3 walkers besides the caller, and some slack
+		t.Errorf("WalkDirParallel started %d goroutines, want about 3", peak)
+	}
+}
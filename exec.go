@@ -0,0 +1,270 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Cmd is an exec.Cmd whose file descriptors are reserved from a Filer.
+//
+// The pipes connecting the command to the Stdin, Stdout, and Stderr of
+// Cmd, including those made by StdinPipe, StdoutPipe, and StderrPipe,
+// hold descriptors of the Filer until Wait returns. Each of those pipe
+// methods, and Start, reserves the descriptors the command needs as
+// configured so far, counting a nil stream as one for os.DevNull. When
+// it must wait for more, it first hands back those reserved but not yet
+// used, so a waiting Cmd holds no more than the pipes it has made.
+//
+// The methods of Cmd must be used rather than those of the embedded
+// *exec.Cmd, and a started Cmd must be waited for with Wait.
+type Cmd struct {
+	*exec.Cmd
+
+	filer *Filer
+	ctx   context.Context
+	held  int         // descriptors reserved, released by Wait
+	spare int         // descriptors reserved but not yet opened
+	pipes []io.Closer // both ends of each pipe made before Start
+}
+
+// Command returns a Cmd to run the named program, see exec.Command.
+func (f *Filer) Command(name string, arg ...string) *Cmd {
+	return &Cmd{
+		Cmd:   exec.Command(name, arg...),
+		filer: f,
+		ctx:   context.Background(),
+	}
+}
+
+// CommandContext is like Command, but the command is killed when ctx
+// is done, and Start gives up waiting for file descriptors.
+// See exec.CommandContext.
+func (f *Filer) CommandContext(ctx context.Context, name string, arg ...string) *Cmd {
+	return &Cmd{
+		Cmd:   exec.CommandContext(ctx, name, arg...),
+		filer: f,
+		ctx:   ctx,
+	}
+}
+
+// execDescriptors reports the descriptors exec.Cmd.Start opens besides
+// those for Stdin, Stdout, and Stderr: a pipe reporting exec failures,
+// and a pidfd on linux. Checked against Go 1.27.
+func execDescriptors() int {
+	if runtime.GOOS == "linux" {
+		return 3
+	}
+	return 2
+}
+
+// streamDescriptors reports the descriptors exec.Cmd.Start opens for
+// a standard stream: none for an *os.File, including the child's end
+// of a pipe, one for os.DevNull if nil, and a pipe copied to or from
+// any other stream.
+func streamDescriptors(stream any) int {
+	switch stream.(type) {
+	case nil:
+		return 1
+	case *os.File:
+		return 0
+	}
+	return 2
+}
+
+// startDescriptors reports the descriptors exec.Cmd.Start opens.
+func (c *Cmd) startDescriptors() int {
+	return execDescriptors() + streamDescriptors(c.Stdin) + streamDescriptors(c.Stdout) + streamDescriptors(c.Stderr)
+}
+
+// reserve grows the descriptors set aside for the pipes and Start still
+// to come to n. Rather than wait for more while holding spare
+// descriptors, it hands them back and waits for all n at once.
+func (c *Cmd) reserve(n int) error {
+	if n <= c.spare {
+		return nil
+	}
+	if err := c.filer.acquireN(c.ctx, false, n-c.spare); err == nil {
+		c.held += n - c.spare
+		c.spare = n
+		return nil
+	}
+	for ; c.spare > 0; c.spare-- {
+		c.filer.release()
+		c.held--
+	}
+	if err := c.filer.acquireN(c.ctx, true, n); err != nil {
+		return err
+	}
+	c.held += n
+	c.spare = n
+	return nil
+}
+
+// reservePipe sets aside the two descriptors of a pipe replacing
+// stream, along with those Start will need.
+func (c *Cmd) reservePipe(stream any) error {
+	if err := c.reserve(2 + c.startDescriptors() - streamDescriptors(stream)); err != nil {
+		return err
+	}
+	c.spare -= 2
+	return nil
+}
+
+// piped records the pipe with ends parent and child made after
+// reservePipe, or sets its descriptors aside again if err is not nil.
+func (c *Cmd) piped(parent io.Closer, child any, err error) {
+	if err != nil {
+		c.spare += 2
+		return
+	}
+	c.pipes = append(c.pipes, parent, child.(io.Closer))
+}
+
+// StdinPipe returns a pipe connected to the standard input of the
+// command, see exec.Cmd.StdinPipe.
+func (c *Cmd) StdinPipe() (io.WriteCloser, error) {
+	if err := c.reservePipe(c.Stdin); err != nil {
+		return nil, err
+	}
+	w, err := c.Cmd.StdinPipe()
+	c.piped(w, c.Stdin, err)
+	return w, err
+}
+
+// StdoutPipe returns a pipe connected to the standard output of the
+// command, see exec.Cmd.StdoutPipe.
+func (c *Cmd) StdoutPipe() (io.ReadCloser, error) {
+	if err := c.reservePipe(c.Stdout); err != nil {
+		return nil, err
+	}
+	r, err := c.Cmd.StdoutPipe()
+	c.piped(r, c.Stdout, err)
+	return r, err
+}
+
+// StderrPipe returns a pipe connected to the standard error of the
+// command, see exec.Cmd.StderrPipe.
+func (c *Cmd) StderrPipe() (io.ReadCloser, error) {
+	if err := c.reservePipe(c.Stderr); err != nil {
+		return nil, err
+	}
+	r, err := c.Cmd.StderrPipe()
+	c.piped(r, c.Stderr, err)
+	return r, err
+}
+
+// Start starts the command, see exec.Cmd.Start.
+func (c *Cmd) Start() error {
+	// Count the descriptors exec.Cmd.Start opens, and which of them
+	// it closes before returning.
+	opened, closed := c.startDescriptors(), 2 // pipe reporting exec failures
+	for _, stream := range []any{c.Stdin, c.Stdout, c.Stderr} {
+		if _, ok := stream.(*os.File); !ok {
+			closed++ // os.DevNull, or the child's end of a pipe it makes
+		}
+	}
+	closed += len(c.pipes) / 2 // the child's end of each pipe
+
+	if err := c.reserve(opened); err != nil {
+		// Like exec.Cmd.Start, close the pipes when failing.
+		for _, p := range c.pipes {
+			p.Close()
+		}
+		c.releaseHeld()
+		return err
+	}
+	for ; c.spare > opened; c.spare-- {
+		c.filer.release()
+		c.held--
+	}
+	c.spare = 0
+	if err := c.Cmd.Start(); err != nil {
+		c.releaseHeld()
+		return err
+	}
+	for i := 0; i < closed; i++ {
+		c.filer.release()
+	}
+	c.held -= closed
+	c.pipes = nil
+	return nil
+}
+
+func (c *Cmd) releaseHeld() {
+	for ; c.held > 0; c.held-- {
+		c.filer.release()
+	}
+	c.spare = 0
+	c.pipes = nil
+}
+
+// Wait waits for the command to exit and releases its file
+// descriptors, see exec.Cmd.Wait.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	c.releaseHeld()
+	return err
+}
+
+// Run starts the command and waits for it to complete, see exec.Cmd.Run.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output,
+// see exec.Cmd.Output.
+func (c *Cmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	captureErr := c.Stderr == nil
+	if captureErr {
+		c.Stderr = &stderr
+	}
+	err := c.Run()
+	var ee *exec.ExitError
+	if captureErr && errors.As(err, &ee) {
+		ee.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its combined standard
+// output and standard error, see exec.Cmd.CombinedOutput.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	if c.Stderr != nil {
+		return nil, errors.New("exec: Stderr already set")
+	}
+	var b bytes.Buffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
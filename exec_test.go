@@ -0,0 +1,136 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFilerCommand(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip(err)
+	}
+	filer := NewFiler(10)
+
+	out, err := filer.Command("echo", "hello").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("Output=%q, want hello", out)
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Output, want 0", open)
+	}
+
+	cmd := filer.Command("cat")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pipes, and what Start needs with Stderr from os.DevNull.
+	if open, want := filer.Stats().Open, 4+execDescriptors()+1; open != want {
+		t.Errorf("Stats().Open=%d with two pipes, want %d reserved", open, want)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	want := 2 // parent ends of the pipes
+	if runtime.GOOS == "linux" {
+		want++ // pidfd
+	}
+	if open := filer.Stats().Open; open != want {
+		t.Errorf("Stats().Open=%d after Start, want %d", open, want)
+	}
+	io.WriteString(stdin, "meow")
+	stdin.Close()
+	b, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "meow" {
+		t.Errorf("cat output %q, want meow", b)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Wait, want 0", open)
+	}
+
+	// A pipe and os.DevNull for Stdin and Stderr is all Start needs.
+	filer.SetLimit(execDescriptors() + 4)
+	cmd = filer.Command("cat")
+	stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(stdout); err != nil || len(b) != 0 {
+		t.Errorf("cat of os.DevNull output %q, %v, want nothing", b, err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second pipe needs one more descriptor than is left, so the
+	// spare ones are handed back while it waits.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cmd = filer.CommandContext(ctx, "cat")
+	stdin, err = cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.StdoutPipe(); err != context.DeadlineExceeded {
+		t.Errorf("StdoutPipe err=%v, want context.DeadlineExceeded", err)
+	}
+	if open := filer.Stats().Open; open != 2 {
+		t.Errorf("Stats().Open=%d waiting for a second pipe, want 2", open)
+	}
+	stdin.Close()
+	if err := cmd.Start(); err != context.DeadlineExceeded {
+		t.Errorf("Start err=%v, want context.DeadlineExceeded", err)
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after failed Start, want 0", open)
+	}
+
+	f, err := filer.TempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cmd = filer.CommandContext(ctx, "cat")
+	if _, err := cmd.StdoutPipe(); err != context.DeadlineExceeded {
+		t.Errorf("StdoutPipe err=%v, want context.DeadlineExceeded", err)
+	}
+	if open := filer.Stats().Open; open != 1 {
+		t.Errorf("Stats().Open=%d after failed StdoutPipe, want 1", open)
+	}
+}
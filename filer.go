@@ -16,13 +16,21 @@ package iox
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"weak"
 )
 
 // A Filer creates files, managing load on file descriptors.
@@ -34,44 +42,231 @@ type Filer struct {
 
 	Logf func(format string, v ...interface{}) // used to report open files at Shutdown
 
-	tempdir string
+	// IdleTimeout, if non-zero, makes Files opened with Open or OpenFile
+	// virtual when they are regular files. The descriptor of a virtual
+	// File that has not been used for IdleTimeout is closed when another
+	// open is waiting for a descriptor. The next use of the File reopens
+	// it by name and restores its offset, failing with ErrReplaced if the
+	// name now refers to a different file.
+	//
+	// Only Read, ReadAt, Write, WriteAt, WriteString, ReadFrom, WriteTo,
+	// Seek, Stat, Sync, Truncate, and Close reopen a virtual File. Other
+	// methods of the embedded *os.File, such as Chmod or Fd, must not be
+	// used.
+	IdleTimeout time.Duration
 
+	// ShareReadOnly, if true, makes Files opened by Open or with
+	// OpenFile(name, os.O_RDONLY, perm) share a single descriptor when
+	// they name the same unmodified regular file. Each File keeps its
+	// own offset, reading with pread. The descriptor is closed when
+	// the last File sharing it is closed.
+	//
+	// The offset of the embedded *os.File of a shared File is
+	// meaningless and its descriptor must not be closed directly.
+	ShareReadOnly bool
+
+	// LeakCheck, if true, reports Files that are garbage collected
+	// without being closed, including the backing files of BufferFiles.
+	// The report, which includes the stack of the creator of the File,
+	// is passed to OnLeak, or to Logf if OnLeak is nil. Without
+	// LeakCheck the Filer keeps unclosed Files until Shutdown.
+	LeakCheck bool
+	OnLeak    func(report string)
+
+	// ReclaimLeaks, if true, closes Files found by LeakCheck,
+	// returning their descriptors to the Filer.
+	ReclaimLeaks bool
+
+	// StackDepth is the number of frames of the creator's stack
+	// recorded for each descriptor, reported by Shutdown, DebugHandler,
+	// and LeakCheck. If zero, only the calling function is recorded,
+	// which is cheaper.
+	StackDepth int
+
+	// AnonymousTemp, if true, creates temporary files that have no
+	// name in the file system, so they disappear even if the process
+	// crashes. This includes the backing files of BufferFiles.
+	// An anonymous file can be given a name with File.Materialize.
+	//
+	// Where the OS or file system does not support anonymous files
+	// (O_TMPFILE on Linux), named temporary files are used.
+	AnonymousTemp bool
+
+	// CleanTempdirAge, if positive, makes the first temporary file
+	// created by the Filer start a CleanTempdir in the background,
+	// removing orphaned files older than CleanTempdirAge.
+	CleanTempdirAge time.Duration
+
+	// TempQuota, if positive, limits the bytes held by the Filer's
+	// temporary files, including the backing files of BufferFiles
+	// but not the files of AtomicFiles, which are meant to be kept.
+	// A write that would exceed it fails with ErrTempQuota, or if
+	// TempQuotaWait is true, waits until enough temporary files
+	// are truncated or closed. The temporary files of a sub-Filer
+	// also count toward the quota of its parent.
+	TempQuota     int64
+	TempQuotaWait bool
+
+	// TempdirPolicy places temporary files when the Filer has several
+	// temporary directories, see SetTempdirs.
+	TempdirPolicy TempdirPolicy
+
+	// TempName, if non-nil, returns the random part of the names of
+	// temporary files. By default it is 16 hex digits from crypto/rand,
+	// so names in a shared directory cannot be predicted.
+	// CleanTempdir only removes files named in the default format.
+	TempName func() string
+
+	tempdirs []*tempdir
+	tempNext atomic.Uint32 // round robin position in tempdirs
+
+	parent *Filer // nil unless created by Sub
+
+	cleanOnce    sync.Once
+	shutdownOnce sync.Once
 	shuttingDown chan struct{} // closed on shutdown
 
-	mu      sync.Mutex
-	cond    *sync.Cond
-	files   map[*File]struct{}
-	fdlimit int
-	seed    uint32
+	mu       sync.Mutex
+	released chan struct{} // closed and replaced when a descriptor is released
+	waiters  []*waiter     // ordered by priority, then arrival
+	used     int           // descriptors held, including files, socks, and subs
+	files    map[fileKey]struct{}
+	socks    map[*sock]struct{}
+	subs     map[*Filer]struct{}
+	shared   map[string]*sharedFD // by name
+	fdlimit  int
+	oslimit  int        // if non-zero, lower limit learned from EMFILE/ENFILE
+	stats    FilerStats // Open and Limit are filled in by Stats
+
+	tempBytes int64 // size of temporary files, see TempQuota
 }
 
 // NewFiler creates a Filer which will open at most fdLimit files simultaneously.
-// If fdLimit is 0, a Filer is limited to 90% of the process's allowed files.
+//
+// If fdLimit is 0, the process's soft RLIMIT_NOFILE is raised to the hard
+// limit (where the OS allows it) and the Filer is limited to 90% of the
+// allowed files not already open in the process.
 func NewFiler(fdLimit int) *Filer {
 	if fdLimit == 0 {
-		var lim syscall.Rlimit
-		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim)
-		fdLimit = int(lim.Max - (lim.Max / 10))
+		fdLimit = defaultFDLimit()
 	}
-	if fdLimit == 0 {
+	if fdLimit <= 0 {
 		fdLimit = 90 // getrlimit failed, guess
 	}
 	filer := &Filer{
 		DefaultBufferMemSize: 1 << 16,
 
-		tempdir:      os.TempDir(),
+		tempdirs:     []*tempdir{{path: os.TempDir()}},
 		shuttingDown: make(chan struct{}),
-		files:        make(map[*File]struct{}),
+		released:     make(chan struct{}),
+		files:        make(map[fileKey]struct{}),
+		socks:        make(map[*sock]struct{}),
+		subs:         make(map[*Filer]struct{}),
+		shared:       make(map[string]*sharedFD),
 		fdlimit:      fdLimit,
 	}
-	filer.cond = sync.NewCond(&filer.mu)
 	return filer
 }
 
+// defaultFDLimit raises the soft RLIMIT_NOFILE toward the hard limit
+// and reports 90% of the file descriptors still available to the process.
+func defaultFDLimit() int {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return 0
+	}
+	if lim.Cur < lim.Max {
+		raised := lim
+		raised.Cur = lim.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err == nil {
+			lim = raised
+		}
+		// Otherwise the limit we can use is the unraised lim.Cur.
+	}
+	cur := int64(lim.Cur)
+	if cur < 0 || cur > math.MaxInt32 {
+		cur = math.MaxInt32 // RLIM_INFINITY
+	}
+	avail := int(cur) - openFDs()
+	return avail - avail/10
+}
+
+// openFDs reports the number of file descriptors open in the process,
+// or 0 if they cannot be counted.
+func openFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		d, err := os.Open(dir)
+		if err != nil {
+			continue
+		}
+		names, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			continue
+		}
+		return len(names) - 1 // not counting d
+	}
+	return 0
+}
+
+// Sub creates a sub-Filer which will open at most fdLimit files
+// simultaneously. Files opened by the sub-Filer also count against the
+// limit of f, so a sub-Filer can be used as a quota for one part of
+// a program without letting it starve the others.
+//
+// The sub-Filer starts with the exported fields and temporary
+// directories of f, except CleanTempdirAge as f sweeps the same
+// directories. Its Stats cover only its own files, while the Stats of
+// f include the files of all its sub-Filers. Shutting down the
+// sub-Filer only closes its own files, while shutting down f also
+// shuts down every sub-Filer.
+func (f *Filer) Sub(fdLimit int) *Filer {
+	if fdLimit <= 0 {
+		panic("iox.Filer.Sub: limit must be positive")
+	}
+	sub := NewFiler(fdLimit)
+	sub.DefaultBufferMemSize = f.DefaultBufferMemSize
+	sub.Logf = f.Logf
+	sub.IdleTimeout = f.IdleTimeout
+	sub.ShareReadOnly = f.ShareReadOnly
+	sub.LeakCheck = f.LeakCheck
+	sub.OnLeak = f.OnLeak
+	sub.ReclaimLeaks = f.ReclaimLeaks
+	sub.StackDepth = f.StackDepth
+	sub.AnonymousTemp = f.AnonymousTemp
+	sub.TempQuota = f.TempQuota
+	sub.TempQuotaWait = f.TempQuotaWait
+	sub.TempdirPolicy = f.TempdirPolicy
+	sub.TempName = f.TempName
+	sub.tempdirs = f.tempdirs
+	sub.parent = f
+
+	f.mu.Lock()
+	f.subs[sub] = struct{}{}
+	f.mu.Unlock()
+
+	return sub
+}
+
+// SetLimit changes the maximum number of files the Filer opens
+// simultaneously. It is safe to call concurrently with other methods.
+//
+// Raising the limit wakes any goroutines waiting for a file descriptor.
+// Lowering it below the number of open files does not close any of
+// them, instead new opens block until enough files are closed.
+func (f *Filer) SetLimit(fdLimit int) {
+	if fdLimit <= 0 {
+		panic("iox.Filer.SetLimit: limit must be positive")
+	}
+	f.mu.Lock()
+	f.fdlimit = fdLimit
+	f.grantLocked()
+	f.mu.Unlock()
+}
+
 // SetTempdir sets the default directory used to hold temporary files.
 func (f *Filer) SetTempdir(tempdir string) {
-	// TODO: just export tempdir field?
-	f.tempdir = tempdir
+	f.SetTempdirs(tempdir)
 }
 
 // Open opens the named file for reading.
@@ -79,9 +274,19 @@ func (f *Filer) SetTempdir(tempdir string) {
 // It is similar to os.Open except it will block if Filer has exhasted
 // its file descriptors until one is available.
 func (f *Filer) Open(name string) (*File, error) {
-	file, err := f.openFile(name, os.O_RDONLY, 0)
+	file, err := f.openFile(context.Background(), true, name, os.O_RDONLY, 0)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// OpenContext is like Open, but gives up waiting for a file descriptor
+// when ctx is done, returning ctx.Err().
+func (f *Filer) OpenContext(ctx context.Context, name string) (*File, error) {
+	file, err := f.openFile(ctx, true, name, os.O_RDONLY, 0)
 	if file != nil {
-		file.pcN = runtime.Callers(0, file.pc[:])
+		f.setCreator(&file.origin)
 	}
 	return file, err
 }
@@ -91,128 +296,639 @@ func (f *Filer) Open(name string) (*File, error) {
 // It is similar to os.OpenFile except it will block if Filer has exhasted
 // its file descriptors until one is available.
 func (f *Filer) OpenFile(name string, flag int, perm os.FileMode) (*File, error) {
-	file, err := f.openFile(name, flag, perm)
+	file, err := f.openFile(context.Background(), true, name, flag, perm)
 	if file != nil {
-		file.pcN = runtime.Callers(0, file.pc[:])
+		f.setCreator(&file.origin)
 	}
 	return file, err
 }
 
-func (f *Filer) openFile(name string, flag int, perm os.FileMode) (*File, error) {
-	file := f.newFile()
-	if file == nil {
-		return nil, context.Canceled
+// OpenFileContext is like OpenFile, but gives up waiting for a file
+// descriptor when ctx is done, returning ctx.Err().
+func (f *Filer) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (*File, error) {
+	file, err := f.openFile(ctx, true, name, flag, perm)
+	if file != nil {
+		f.setCreator(&file.origin)
 	}
-	osfile, err := os.OpenFile(name, flag, perm)
+	return file, err
+}
+
+// ErrNoDescriptors is reported by the Try methods of Filer when
+// no file descriptor is available.
+var ErrNoDescriptors = errors.New("iox: no file descriptors available")
+
+// ErrTooManyDescriptors is reported when more file descriptors are
+// requested at once, such as by Reserve, than the limit of the Filer,
+// so they could never be granted. It is also reported to waiters when
+// the limit drops below what they wait for.
+var ErrTooManyDescriptors = errors.New("iox: more file descriptors requested than the Filer limit")
+
+// TryOpen is like Open, but reports ErrNoDescriptors instead of
+// blocking when the Filer has exhausted its file descriptors.
+func (f *Filer) TryOpen(name string) (*File, error) {
+	file, err := f.openFile(context.Background(), false, name, os.O_RDONLY, 0)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// TryOpenFile is like OpenFile, but reports ErrNoDescriptors instead of
+// blocking when the Filer has exhausted its file descriptors.
+func (f *Filer) TryOpenFile(name string, flag int, perm os.FileMode) (*File, error) {
+	file, err := f.openFile(context.Background(), false, name, flag, perm)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+func (f *Filer) openFile(ctx context.Context, wait bool, name string, flag int, perm os.FileMode) (*File, error) {
+	if f.ShareReadOnly && flag == os.O_RDONLY {
+		if file, err := f.openShared(ctx, wait, name); file != nil || err != nil {
+			return file, err
+		}
+	}
+	osfile, err := f.openOS(ctx, wait, name, flag, perm)
 	if err != nil {
-		file.remove()
 		return nil, err
 	}
-	file.File = osfile
+	file := &File{
+		File:  osfile,
+		filer: f,
+		flag:  flag,
+	}
+	if f.IdleTimeout > 0 {
+		if fi, err := osfile.Stat(); err == nil && fi.Mode().IsRegular() {
+			file.virtual = true
+		}
+	}
+	f.addFile(file)
 	return file, nil
 }
 
+// openOS opens the named file with a descriptor reserved by acquire.
+func (f *Filer) openOS(ctx context.Context, wait bool, name string, flag int, perm os.FileMode) (*os.File, error) {
+	return f.openWith(ctx, wait, func() (*os.File, error) {
+		return os.OpenFile(name, flag, perm)
+	})
+}
+
+// openWith calls open with a descriptor reserved by acquire.
+func (f *Filer) openWith(ctx context.Context, wait bool, open func() (*os.File, error)) (*os.File, error) {
+	for {
+		if err := f.acquire(ctx, wait); err != nil {
+			return nil, err
+		}
+		osfile, err := open()
+		if err != nil {
+			if isTooManyFiles(err) && f.osLimited() {
+				// Wait for a descriptor to close and try again.
+				// A reserved descriptor was handed back to f.
+				ctx = context.WithValue(ctx, reservationKey{}, (*Reservation)(nil))
+				continue
+			}
+			if r := f.reservation(ctx); r != nil {
+				r.giveBack(1)
+			} else {
+				f.release()
+			}
+			return nil, err
+		}
+		return osfile, nil
+	}
+}
+
+// isTooManyFiles reports whether err is the OS reporting that the
+// process (EMFILE) or system (ENFILE) is out of file descriptors.
+func isTooManyFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// TempFile creates a new temporary file in the directory dir,
+// removed when the File is closed.
+//
+// The file name begins with prefix and ends with suffix.
+// If dir is the empty string, the Filer's tempdir is used.
 func (f *Filer) TempFile(dir, prefix, suffix string) (file *File, err error) {
-	if dir == "" {
-		dir = f.tempdir
+	file, err = f.tempFile(context.Background(), true, dir, prefix, suffix, 0600)
+	if file != nil {
+		f.setCreator(&file.origin)
 	}
+	return file, err
+}
+
+// TempFileContext is like TempFile, but gives up waiting for a file
+// descriptor when ctx is done, returning ctx.Err().
+func (f *Filer) TempFileContext(ctx context.Context, dir, prefix, suffix string) (file *File, err error) {
+	file, err = f.tempFile(ctx, true, dir, prefix, suffix, 0600)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// TryTempFile is like TempFile, but reports ErrNoDescriptors instead of
+// blocking when the Filer has exhausted its file descriptors.
+func (f *Filer) TryTempFile(dir, prefix, suffix string) (file *File, err error) {
+	file, err = f.tempFile(context.Background(), false, dir, prefix, suffix, 0600)
+	if file != nil {
+		f.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+func (f *Filer) tempFile(ctx context.Context, wait bool, dir, prefix, suffix string, perm os.FileMode) (file *File, err error) {
+	if f.CleanTempdirAge > 0 {
+		f.cleanOnce.Do(func() { go f.cleanTempdir() })
+	}
+	if dir != "" {
+		return f.tempFileIn(ctx, wait, dir, prefix, suffix, perm)
+	}
+	for _, td := range f.pickTempdirs() {
+		file, err = f.tempFileIn(ctx, wait, td.path, prefix, suffix, perm)
+		if err == nil {
+			file.tempdir = td
+			return file, nil
+		}
+		var pathErr *os.PathError
+		if !errors.As(err, &pathErr) {
+			return nil, err // not a problem with the directory
+		}
+		td.fail(err)
+	}
+	return nil, err
+}
+
+func (f *Filer) tempFileIn(ctx context.Context, wait bool, dir, prefix, suffix string, perm os.FileMode) (file *File, err error) {
+	if f.AnonymousTemp {
+		file, err = f.anonTempFile(ctx, wait, dir, prefix, suffix, perm)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return file, err
+		}
+	}
+	return f.namedTempFile(ctx, wait, dir, prefix, suffix, perm)
+}
+
+func (f *Filer) namedTempFile(ctx context.Context, wait bool, dir, prefix, suffix string, perm os.FileMode) (file *File, err error) {
 	for i := 0; i < 1000; i++ {
-		name := filepath.Join(dir, prefix+f.rand()+suffix)
-		file, err = f.openFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		name := f.tempName(dir, prefix, suffix)
+		var osfile *os.File
+		osfile, err = f.openOS(ctx, wait, name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
 		if os.IsExist(err) {
 			continue
 		}
-		break
+		if err != nil {
+			return nil, err
+		}
+		file = &File{
+			File:   osfile,
+			filer:  f,
+			isTemp: true,
+		}
+		f.addFile(file)
+		return file, nil
 	}
-	if file != nil {
-		file.pcN = runtime.Callers(0, file.pc[:])
-		file.isTemp = true
+	return nil, err
+}
+
+// anonTempFile creates a temporary file in dir that has no name,
+// reporting errors.ErrUnsupported if the OS or file system cannot.
+// The File is named prefix+random+suffix in dir, but the name does not
+// exist unless given to the file with Materialize.
+func (f *Filer) anonTempFile(ctx context.Context, wait bool, dir, prefix, suffix string, perm os.FileMode) (*File, error) {
+	name := f.tempName(dir, prefix, suffix)
+	osfile, err := f.openWith(ctx, wait, func() (*os.File, error) {
+		return openTmpfile(dir, name, perm)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return file, err
+	file := &File{
+		File:      osfile,
+		filer:     f,
+		isTemp:    true,
+		anonymous: true,
+	}
+	f.addFile(file)
+	return file, nil
+}
+
+// Materialize gives the temporary file the name name, so it outlives
+// the File. For a file created with AnonymousTemp, name is linked to the
+// file, which otherwise never appears in the file system. The Name of
+// the File is unchanged.
+func (file *File) Materialize(name string) error {
+	if !file.isTemp {
+		return &os.LinkError{Op: "materialize", Old: file.File.Name(), New: name, Err: os.ErrInvalid}
+	}
+	if !file.anonymous {
+		return os.Link(file.File.Name(), name)
+	}
+	if file.shared != nil || file.virtual {
+		return &os.LinkError{Op: "materialize", Old: file.File.Name(), New: name, Err: os.ErrInvalid}
+	}
+	if err := linkFD(file.File, name); err != nil {
+		return &os.LinkError{Op: "materialize", Old: file.File.Name(), New: name, Err: err}
+	}
+	return nil
 }
 
-// Shutdown gracefully shuts down the Filer.
-// Any active files continue to work until the passed context is done.
+// Shutdown gracefully shuts down the Filer and its sub-Filers.
+// Any active files and connections continue to work until the passed context is done.
 // At that point they are explicitly closed and further operations return errors.
 // Shutdown returns the error from ctx.
 func (f *Filer) Shutdown(ctx context.Context) error {
-	close(f.shuttingDown)
-	f.cond.Broadcast()
-	done := make(chan struct{})
+	f.shutdownOnce.Do(func() { close(f.shuttingDown) })
 
-	go func() {
-		select {
-		case <-ctx.Done():
-			f.cond.Broadcast()
-		case <-done:
-		}
-	}()
+	if f.parent != nil {
+		f.parent.mu.Lock()
+		delete(f.parent.subs, f)
+		f.parent.mu.Unlock()
+	}
 
+	var wg sync.WaitGroup
 	f.mu.Lock()
+	for sub := range f.subs {
+		wg.Add(1)
+		go func(sub *Filer) {
+			sub.Shutdown(ctx)
+			wg.Done()
+		}(sub)
+	}
+	defer wg.Wait()
+
 	for {
+		f.reclaimCollectedLocked()
+		forced := false
 		select {
 		case <-ctx.Done():
-			for file := range f.files {
+			forced = true
+			for key := range f.files {
+				file := key.value()
 				if f.Logf != nil {
-					f.Logf("iox.Filer.Shutdown: closing file created by %s: %s", file.creator(), file.File.Name())
+					f.Logf("iox.Filer.Shutdown: closing file created by %s: %s%s", file.creator(), file.File.Name(), file.traceback())
 				}
 				file.File.Close()
-				delete(f.files, file)
+				f.removeLocked(file)
+			}
+			for s := range f.socks {
+				if f.Logf != nil {
+					f.Logf("iox.Filer.Shutdown: closing %s created by %s: %s%s", s.kind, s.creator(), s.name, s.traceback())
+				}
+				s.closer.Close()
+				delete(f.socks, s)
+				f.releaseLocked()
 			}
-			// now len(f.files) == 0
+			// now len(f.files) == 0 && len(f.socks) == 0
 		default:
 			if f.Logf != nil {
-				for file := range f.files {
-					f.Logf("iox.Filer.Shutdown: waiting for file created by %s: %s", file.creator(), file.File.Name())
+				for key := range f.files {
+					file := key.value()
+					f.Logf("iox.Filer.Shutdown: waiting for file created by %s: %s%s", file.creator(), file.File.Name(), file.traceback())
+				}
+				for s := range f.socks {
+					f.Logf("iox.Filer.Shutdown: waiting for %s created by %s: %s%s", s.kind, s.creator(), s.name, s.traceback())
 				}
 			}
 		}
-		if len(f.files) == 0 {
+		if f.used == 0 || forced {
 			break
 		}
-		f.cond.Wait()
+		released := f.released
+		f.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-released:
+		}
+		f.mu.Lock()
 	}
 	f.mu.Unlock()
 
-	close(done)
 	return ctx.Err()
 }
 
-func (f *Filer) newFile() *File {
-	file := &File{filer: f}
+// acquire reserves a file descriptor.
+//
+// It blocks until a descriptor is available, the Filer is shut down
+// (reported as context.Canceled), or ctx is done.
+// If wait is false and no descriptor is available, it reports
+// ErrNoDescriptors instead of blocking.
+//
+// The descriptor must be handed back with release, or
+// registered with newFile.
+//
+// The descriptor of a sub-Filer is also reserved from its parent.
+func (f *Filer) acquire(ctx context.Context, wait bool) error {
+	_, err := f.acquireTimed(ctx, wait, 1)
+	return err
+}
+
+// acquireN is acquire for n descriptors, reserved all at once so
+// goroutines each holding some descriptors cannot deadlock waiting
+// for more. Each descriptor is handed back with its own release.
+func (f *Filer) acquireN(ctx context.Context, wait bool, n int) error {
+	_, err := f.acquireTimed(ctx, wait, n)
+	return err
+}
+
+// acquireTimed is acquireN, also reporting how long it waited.
+func (f *Filer) acquireTimed(ctx context.Context, wait bool, n int) (time.Duration, error) {
+	if r := f.reservation(ctx); r != nil {
+		return 0, r.take(n)
+	}
+	waited, err := f.acquireLocal(ctx, wait, n)
+	if err != nil {
+		return 0, err
+	}
+	if f.parent != nil {
+		parentWaited, err := f.parent.acquireTimed(ctx, wait, n)
+		if err != nil {
+			f.mu.Lock()
+			f.handBackLocked(n)
+			f.mu.Unlock()
+			return 0, err
+		}
+		waited += parentWaited
+	}
+
+	f.mu.Lock()
+	f.stats.opened(f.used, n, waited)
+	f.mu.Unlock()
+	return waited, nil
+}
 
+// acquireLocal reserves n file descriptors from f, ignoring its parent.
+//
+// Waiters are granted descriptors in order of priority, as set by
+// WithPriority, then in order of arrival.
+func (f *Filer) acquireLocal(ctx context.Context, wait bool, n int) (time.Duration, error) {
 	f.mu.Lock()
-	for {
+	if n > f.limitLocked() {
+		f.mu.Unlock()
+		return 0, ErrTooManyDescriptors
+	}
+	select {
+	case <-f.shuttingDown:
+		f.mu.Unlock()
+		return 0, context.Canceled
+	case <-ctx.Done():
+		f.mu.Unlock()
+		return 0, ctx.Err()
+	default:
+	}
+	f.evictForLocked(n)
+	if len(f.waiters) == 0 && f.used+n <= f.limitLocked() {
+		f.used += n
+		f.mu.Unlock()
+		return 0, nil
+	}
+	if !wait {
+		f.mu.Unlock()
+		return 0, ErrNoDescriptors
+	}
+	w := &waiter{
+		n:     n,
+		prio:  priority(ctx),
+		ready: make(chan struct{}),
+	}
+	f.enqueueLocked(w)
+	f.mu.Unlock()
+
+	var idle <-chan time.Time // ticks when files may have become idle
+	if f.IdleTimeout > 0 {
+		t := time.NewTicker(f.IdleTimeout)
+		defer t.Stop()
+		idle = t.C
+	}
+
+	start := time.Now()
+	var err error
+	for err == nil {
 		select {
+		case <-w.ready:
+			if w.err != nil {
+				return 0, w.err
+			}
+			return time.Since(start), nil
 		case <-f.shuttingDown:
+			err = context.Canceled
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-idle:
+			f.mu.Lock()
+			f.evictForLocked(n)
 			f.mu.Unlock()
-			return nil
-		default:
 		}
-		if len(f.files) < f.fdlimit {
-			break
+	}
+
+	f.mu.Lock()
+	select {
+	case <-w.ready:
+		if w.err == nil {
+			f.handBackLocked(n) // granted as we gave up, hand it on
 		}
-		f.cond.Wait()
+	default:
+		f.dequeueLocked(w)
+		f.grantLocked()
 	}
-	f.files[file] = struct{}{}
 	f.mu.Unlock()
+	return 0, err
+}
 
-	return file
+// A waiter is a goroutine in acquire waiting for file descriptors.
+type waiter struct {
+	n     int // descriptors wanted
+	prio  int
+	ready chan struct{} // closed when the descriptors are granted or err is set
+	err   error
 }
 
-func (f *Filer) rand() string {
-	const mod = 0x7fffffff
+// enqueueLocked adds w to f.waiters behind all waiters of the
+// same or higher priority. It is called with f.mu held.
+func (f *Filer) enqueueLocked(w *waiter) {
+	i := len(f.waiters)
+	for i > 0 && f.waiters[i-1].prio < w.prio {
+		i--
+	}
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = w
+	f.stats.Waiting++
+}
+
+// dequeueLocked removes w from f.waiters. It is called with f.mu held.
+func (f *Filer) dequeueLocked(w *waiter) {
+	for i, w2 := range f.waiters {
+		if w2 == w {
+			copy(f.waiters[i:], f.waiters[i+1:])
+			f.waiters[len(f.waiters)-1] = nil
+			f.waiters = f.waiters[:len(f.waiters)-1]
+			f.stats.Waiting--
+			return
+		}
+	}
+}
+
+// grantLocked hands out free descriptors to waiters in queue order.
+// A waiter for several descriptors holds up those behind it until
+// enough are free, unless the limit has dropped below what it wants,
+// in which case it fails with ErrTooManyDescriptors.
+// It is called with f.mu held.
+func (f *Filer) grantLocked() {
+	for len(f.waiters) > 0 {
+		w := f.waiters[0]
+		switch {
+		case w.n > f.limitLocked():
+			w.err = ErrTooManyDescriptors
+		case f.used+w.n > f.limitLocked():
+			return
+		default:
+			f.used += w.n
+		}
+		copy(f.waiters, f.waiters[1:])
+		f.waiters[len(f.waiters)-1] = nil
+		f.waiters = f.waiters[:len(f.waiters)-1]
+		f.stats.Waiting--
+		close(w.ready)
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that gives the Filer methods it is
+// passed to the priority prio when waiting for a file descriptor.
+//
+// Waiters with a higher priority are granted descriptors first.
+// Waiters of the same priority are granted descriptors in the order
+// they arrived. The default priority is 0.
+func WithPriority(ctx context.Context, prio int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, prio)
+}
+
+func priority(ctx context.Context) int {
+	prio, _ := ctx.Value(priorityKey{}).(int)
+	return prio
+}
 
+// release hands back a descriptor reserved by acquire.
+func (f *Filer) release() {
 	f.mu.Lock()
-	for f.seed == 0 {
-		f.seed = uint32((time.Now().UnixNano() + int64(os.Getpid())) % mod)
+	f.releaseLocked()
+	f.mu.Unlock()
+}
+
+// releaseLocked is release called with f.mu held.
+func (f *Filer) releaseLocked() {
+	f.handBackLocked(1)
+	if f.parent != nil {
+		f.parent.release()
 	}
-	// Park-Miller RNG, constants from wikipedia.
-	v := uint32(uint64(f.seed) * 48271 % mod)
-	f.seed = v
+}
+
+// handBackLocked hands back n descriptors reserved from f but not
+// from its parent, waking Shutdown. It is called with f.mu held.
+func (f *Filer) handBackLocked(n int) {
+	f.used -= n
+	f.grantLocked()
+	f.wakeLocked()
+}
+
+// osLimited is called when opening a descriptor reserved by acquire
+// failed because the OS ran out of file descriptors, typically because
+// of descriptors opened outside the Filer. It hands back the reserved
+// descriptor and lowers the Filer's limit to the number of descriptors
+// currently open, so the open can be retried after one of them closes.
+//
+// If no other descriptors are open there is nothing to wait for and
+// osLimited reports false, leaving the descriptor reserved.
+//
+// The OS limit is process-wide, so it is learned by the root Filer.
+func (f *Filer) osLimited() bool {
+	root := f.root()
+	root.mu.Lock()
+	root.stats.OSLimited++
+	open := root.used - 1
+	if open > 0 {
+		root.oslimit = open
+	}
+	root.mu.Unlock()
+
+	if open == 0 {
+		return false
+	}
+	f.release()
+	return true
+}
+
+// addFile registers file, opened with a descriptor reserved by acquire.
+func (f *Filer) addFile(file *File) {
+	file.created = time.Now()
+	file.lastUse.Store(file.created.UnixNano())
+
+	f.mu.Lock()
+	f.trackLocked(file)
 	f.mu.Unlock()
+	f.opened()
+}
+
+// opened is called after a descriptor reserved by acquire is
+// successfully opened.
+func (f *Filer) opened() {
+	root := f.root()
+	root.mu.Lock()
+	if root.oslimit > 0 {
+		// Probe for more room now that an open succeeded.
+		root.oslimit++
+		if root.oslimit >= root.fdlimit {
+			root.oslimit = 0
+		}
+		root.grantLocked()
+	}
+	root.mu.Unlock()
+}
+
+// root reports the Filer at the top of the Sub hierarchy.
+func (f *Filer) root() *Filer {
+	for f.parent != nil {
+		f = f.parent
+	}
+	return f
+}
+
+// limitLocked reports the number of files the Filer may currently open.
+// It is called with f.mu held.
+func (f *Filer) limitLocked() int {
+	if f.oslimit > 0 && f.oslimit < f.fdlimit {
+		return f.oslimit
+	}
+	return f.fdlimit
+}
+
+// wakeLocked wakes all goroutines waiting on f.released,
+// such as Shutdown.
+// It is called with f.mu held.
+func (f *Filer) wakeLocked() {
+	close(f.released)
+	f.released = make(chan struct{})
+}
 
-	return strconv.FormatUint(uint64(v), 16)
+// tempName returns a name for a temporary file in dir. It includes
+// the pid of the process, which CleanTempdir uses to find orphans.
+func (f *Filer) tempName(dir, prefix, suffix string) string {
+	return filepath.Join(dir, prefix+".iox-"+strconv.Itoa(os.Getpid())+"-"+f.rand()+suffix)
+}
+
+// rand returns the random part of a temporary file name.
+func (f *Filer) rand() string {
+	if f.TempName != nil {
+		return f.TempName()
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("iox: crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
 }
 
 // File is an *os.File managed by a Filer.
@@ -221,19 +937,173 @@ func (f *Filer) rand() string {
 type File struct {
 	*os.File
 
-	filer  *Filer
-	isTemp bool
+	origin
+
+	filer      *Filer
+	isTemp     bool
+	anonymous  bool // temp file without a name, see AnonymousTemp
+	bufferFile bool // backing file of a BufferFile
+	atomicFile bool // temporary file of an AtomicFile
+	pipe       bool // end of a Pipe
+
+	flag    int         // flag passed to OpenFile
+	virtual bool        // descriptor may be closed while idle, see IdleTimeout
+	fi      os.FileInfo // of a virtual file when last parked
+	lastUse atomic.Int64
+	vmu     sync.Mutex // held while a virtual file is in use
+	off     int64      // offset of a parked virtual file, guarded by vmu
+	parked  bool       // descriptor closed while idle, guarded by vmu and filer.mu
+
+	shared *sharedFD // descriptor shared with other Files, see ShareReadOnly
+	closed bool      // closed shared File, guarded by vmu
+
+	key fileKey // of this File in filer.files
+
+	tempSize int64    // charged to filer.tempBytes, guarded by filer.mu
+	tempdir  *tempdir // of a temp file in one of filer.tempdirs
+}
+
+// fileKey identifies a File in Filer.files. With LeakCheck it holds
+// the File weakly, so a File that is not closed can be collected and
+// reported, otherwise the Filer keeps it alive until it is closed.
+type fileKey struct {
+	strong *File
+	weak   weak.Pointer[File]
+}
+
+// value reports the File of k, or nil if it was garbage collected.
+func (k fileKey) value() *File {
+	if k.strong != nil {
+		return k.strong
+	}
+	return k.weak.Value()
+}
+
+// trackLocked adds file to f.files. It is called with f.mu held.
+func (f *Filer) trackLocked(file *File) {
+	if f.LeakCheck {
+		file.key = fileKey{weak: weak.Make(file)}
+		runtime.SetFinalizer(file, (*File).leaked)
+	} else {
+		file.key = fileKey{strong: file}
+	}
+	f.files[file.key] = struct{}{}
+}
 
-	// runtime.Callers where the File was created
+// reclaimCollectedLocked removes the Files of f that were garbage
+// collected without being closed, handing back their descriptors.
+// It is called with f.mu held.
+func (f *Filer) reclaimCollectedLocked() {
+	for key := range f.files {
+		if key.value() == nil {
+			delete(f.files, key)
+			f.releaseLocked()
+		}
+	}
+}
+
+// leaked is the finalizer of a File tracked with LeakCheck.
+func (file *File) leaked() {
+	f := file.filer
+	f.mu.Lock()
+	_, open := f.files[file.key]
+	report := fmt.Sprintf("iox.Filer: %s created by %s was not closed: %s\n\t%s",
+		file.kind(), file.creator(), file.File.Name(), strings.Join(file.stack(), "\n\t"))
+	f.mu.Unlock()
+	if !open {
+		return
+	}
+
+	if f.OnLeak != nil {
+		f.OnLeak(report)
+	} else if f.Logf != nil {
+		f.Logf("%s", report)
+	}
+	if f.ReclaimLeaks {
+		file.Close()
+	}
+}
+
+// kind describes file in reports: "file", "dir", "pipe", "temp", or
+// "bufferfile".
+func (file *File) kind() string {
+	switch {
+	case file.pipe:
+		return "pipe"
+	case file.flag&syscall.O_DIRECTORY != 0:
+		return "dir"
+	case file.bufferFile:
+		return "bufferfile"
+	case file.isTemp:
+		return "temp"
+	}
+	return "file"
+}
+
+// origin records where a descriptor managed by a Filer was created.
+type origin struct {
+	created time.Time
+
+	// runtime.Callers where the descriptor was created
 	pc  [3]uintptr
 	pcN int
+	stk []uintptr // replaces pc if Filer.StackDepth is set
 }
 
-func (file *File) remove() {
-	file.filer.mu.Lock()
-	delete(file.filer.files, file)
-	file.filer.cond.Signal()
-	file.filer.mu.Unlock()
+// setCreator records the caller of the exported method that
+// created the descriptor described by o.
+func (f *Filer) setCreator(o *origin) {
+	var pc [3]uintptr
+	var pcN int
+	var stk []uintptr
+	if f.StackDepth > 0 {
+		stk = make([]uintptr, f.StackDepth+2)
+		stk = stk[:runtime.Callers(1, stk)]
+	} else {
+		pcN = runtime.Callers(1, pc[:])
+	}
+
+	f.mu.Lock()
+	o.pc = pc
+	o.pcN = pcN
+	o.stk = stk
+	f.mu.Unlock()
+}
+
+// remove unregisters file, reporting false if it was already removed.
+func (file *File) remove() bool {
+	f := file.filer
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.removeLocked(file)
+}
+
+// removeLocked is remove called with f.mu held.
+func (f *Filer) removeLocked(file *File) bool {
+	if _, ok := f.files[file.key]; !ok {
+		return false
+	}
+	delete(f.files, file.key)
+	if file.tempSize > 0 {
+		f.dischargeTempLocked(file.tempSize)
+		file.tempSize = 0
+	}
+	switch {
+	case file.parked:
+		f.stats.Parked--
+	case file.shared != nil:
+		file.shared.refs--
+		if file.shared.refs == 0 {
+			file.shared.file.Close()
+			if f.shared[file.shared.name] == file.shared {
+				delete(f.shared, file.shared.name)
+			}
+			f.releaseLocked()
+		}
+	default:
+		f.releaseLocked()
+	}
+	return true
 }
 
 // Close closes the underlying file descriptor and informs the Filer.
@@ -241,10 +1111,33 @@ func (file *File) Close() error {
 	if file == nil || file.File == nil {
 		return os.ErrInvalid
 	}
+	if file.filer.LeakCheck {
+		runtime.SetFinalizer(file, nil)
+	}
+	if file.shared != nil {
+		file.vmu.Lock()
+		defer file.vmu.Unlock()
+		wasClosed := file.closed
+		file.closed = true
+		if wasClosed || !file.remove() {
+			return &os.PathError{Op: "close", Path: file.File.Name(), Err: os.ErrClosed}
+		}
+		return nil
+	}
+	if file.virtual {
+		file.vmu.Lock()
+		defer file.vmu.Unlock()
+		if file.parked {
+			if !file.remove() {
+				return &os.PathError{Op: "close", Path: file.File.Name(), Err: os.ErrClosed}
+			}
+			return nil
+		}
+	}
 	err := file.File.Close()
 	file.remove()
 
-	if file.isTemp {
+	if file.isTemp && !file.anonymous {
 		rmErr := os.Remove(file.File.Name())
 		if err == nil {
 			err = rmErr
@@ -253,17 +1146,59 @@ func (file *File) Close() error {
 	return err
 }
 
-func (file *File) creator() string {
-	if file.pcN > 0 {
-		frames := runtime.CallersFrames(file.pc[:file.pcN])
-		if _, more := frames.Next(); more { // runtime.Callers
-			if _, more := frames.Next(); more { // filer.<exported function>
-				frame, _ := frames.Next() // caller we care about
-				if frame.Function != "" {
-					return frame.Function
-				}
-			}
+func (o *origin) creator() string {
+	if frames := o.callers(); frames != nil {
+		frame, _ := frames.Next()
+		if frame.Function != "" {
+			return frame.Function
 		}
 	}
 	return "<unknown>"
 }
+
+// stack formats the recorded stack of the creator,
+// one "function\n\tfile:line" entry per frame.
+func (o *origin) stack() []string {
+	var stack []string
+	if frames := o.callers(); frames != nil {
+		for {
+			frame, more := frames.Next()
+			if frame.Function != "" {
+				stack = append(stack, fmt.Sprintf("%s\n\t%s:%d", frame.Function, frame.File, frame.Line))
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	return stack
+}
+
+// traceback is the recorded stack formatted for logs, or the empty
+// string if only the creator was recorded.
+func (o *origin) traceback() string {
+	if o.stk == nil {
+		return ""
+	}
+	return "\n\t" + strings.Join(o.stack(), "\n\t")
+}
+
+// callers returns the recorded frames of the creator,
+// positioned at the caller of the exported creating method.
+func (o *origin) callers() *runtime.Frames {
+	pc := o.stk
+	if pc == nil {
+		pc = o.pc[:o.pcN]
+	}
+	if len(pc) == 0 {
+		return nil
+	}
+	frames := runtime.CallersFrames(pc)
+	if _, more := frames.Next(); !more { // runtime.Callers or setCreator
+		return nil
+	}
+	if _, more := frames.Next(); !more { // filer.<exported function>
+		return nil
+	}
+	return frames
+}
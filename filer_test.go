@@ -21,7 +21,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -93,6 +96,81 @@ func underlyingError(err error) error {
 	return err
 }
 
+func TestFilerDefaultLimit(t *testing.T) {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		t.Skip(err)
+	}
+	if n := openFDs(); n < 3 {
+		t.Errorf("openFDs()=%d, want at least stdin, stdout, and stderr", n)
+	}
+
+	limit := NewFiler(0).Stats().Limit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		t.Fatal(err)
+	}
+	if limit <= 0 || uint64(limit) >= uint64(lim.Cur) {
+		t.Errorf("default limit %d, want between 0 and the soft rlimit %d", limit, lim.Cur)
+	}
+}
+
+// setRlimitCur sets *cur to n, as Rlimit fields are signed on some platforms.
+func setRlimitCur[T int64 | uint64](cur *T, n int) {
+	*cur = T(n)
+}
+
+func TestFilerOSLimited(t *testing.T) {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		t.Skip(err)
+	}
+	filer := NewFiler(100)
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave the process room for one more descriptor, which is
+	// taken by a file opened outside of the filer.
+	lowLim := lim
+	setRlimitCur(&lowLim.Cur, openFDs()+1)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowLim); err != nil {
+		t.Skip(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
+	outside, err := os.Open(f1.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outside.Close()
+
+	if _, err := filer.TryOpen(f1.Name()); err != ErrNoDescriptors {
+		t.Errorf("TryOpen with no OS descriptors err=%v, want ErrNoDescriptors", err)
+	}
+	if s := filer.Stats(); s.OSLimited != 1 || s.Limit != 1 {
+		t.Errorf("Stats()=%+v, want OSLimited=1, Limit=1", s)
+	}
+
+	f2ch := make(chan *File)
+	go func() {
+		f2, err := filer.Open(os.DevNull)
+		if err != nil {
+			t.Error(err)
+		}
+		f2ch <- f2
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if s := filer.Stats(); s.Waiting != 1 {
+		t.Errorf("Stats().Waiting=%d, want Open blocked on the OS limit", s.Waiting)
+	}
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f2 := <-f2ch; f2 != nil {
+		f2.Close()
+	}
+}
+
 func TestFilerShutdownClean(t *testing.T) {
 	filer := NewFiler(2)
 	f1, err := filer.TempFile("", "testfile1", "")
@@ -134,6 +212,267 @@ func TestFilerShutdownClean(t *testing.T) {
 	}
 }
 
+func TestFilerContext(t *testing.T) {
+	filer := NewFiler(1)
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := filer.TempFileContext(ctx, "", "testfile2", ""); err != context.DeadlineExceeded {
+		t.Errorf("TempFileContext err=%v, want context.DeadlineExceeded", err)
+	}
+	if _, err := filer.OpenContext(ctx, f1.Name()); err != context.DeadlineExceeded {
+		t.Errorf("OpenContext err=%v, want context.DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		_, err := filer.OpenFileContext(ctx, f1.Name(), os.O_RDONLY, 0)
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("OpenFileContext err=%v, want context.Canceled", err)
+	}
+
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The abandoned waits must not have leaked a descriptor.
+	f2, err := filer.TempFileContext(context.Background(), "", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilerTry(t *testing.T) {
+	filer := NewFiler(1)
+	f1, err := filer.TryTempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filer.TryTempFile("", "testfile2", ""); err != ErrNoDescriptors {
+		t.Errorf("TryTempFile err=%v, want ErrNoDescriptors", err)
+	}
+	if _, err := filer.TryOpen(f1.Name()); err != ErrNoDescriptors {
+		t.Errorf("TryOpen err=%v, want ErrNoDescriptors", err)
+	}
+	if _, err := filer.TryOpenFile(f1.Name(), os.O_RDONLY, 0); err != ErrNoDescriptors {
+		t.Errorf("TryOpenFile err=%v, want ErrNoDescriptors", err)
+	}
+	name := f1.Name()
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := filer.TryOpen(name); !os.IsNotExist(err) {
+		t.Errorf("TryOpen of removed temp file err=%v, want os.IsNotExist", err)
+	}
+	f2, err := filer.TryTempFile("", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilerStats(t *testing.T) {
+	filer := NewFiler(1)
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		if s := filer.Stats(); s.Waiting != 1 {
+			t.Errorf("Stats().Waiting=%d, want 1", s.Waiting)
+		}
+		f1.Close()
+	}()
+	f2, err := filer.TempFile("", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := filer.Stats()
+	if s.Open != 1 || s.Limit != 1 || s.Waiting != 0 || s.PeakOpen != 1 {
+		t.Errorf("Stats()=%+v, want Open=1, Limit=1, Waiting=0, PeakOpen=1", s)
+	}
+	if s.TotalOpens != 2 {
+		t.Errorf("Stats().TotalOpens=%d, want 2", s.TotalOpens)
+	}
+	if s.TotalWait < 20*time.Millisecond {
+		t.Errorf("Stats().TotalWait=%v, want at least 20ms", s.TotalWait)
+	}
+	if s.WaitHistogram[0] != 1 || s.WaitHistogram[1] != 0 {
+		t.Errorf("Stats().WaitHistogram=%v, want one open without a wait and one over 10ms", s.WaitHistogram)
+	}
+
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := filer.Stats(); s.Open != 0 {
+		t.Errorf("Stats().Open=%d after close, want 0", s.Open)
+	}
+}
+
+func TestFilerSetLimit(t *testing.T) {
+	filer := NewFiler(1)
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f2ch := make(chan *File)
+	go func() {
+		f2, err := filer.TempFile("", "testfile2", "")
+		if err != nil {
+			t.Error(err)
+		}
+		f2ch <- f2
+	}()
+	time.Sleep(10 * time.Millisecond)
+	filer.SetLimit(2)
+	f2 := <-f2ch
+	if s := filer.Stats(); s.Open != 2 || s.Limit != 2 {
+		t.Errorf("after raising limit Stats()=%+v, want Open=2, Limit=2", s)
+	}
+
+	filer.SetLimit(1)
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filer.TryTempFile("", "testfile3", ""); err != ErrNoDescriptors {
+		t.Errorf("TryTempFile over lowered limit err=%v, want ErrNoDescriptors", err)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f3, err := filer.TryTempFile("", "testfile3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f3.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilerSub(t *testing.T) {
+	filer := NewFiler(3)
+	a := filer.Sub(2)
+	b := filer.Sub(2)
+
+	a1, err := a.TempFile("", "a1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := a.TempFile("", "a2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.TryTempFile("", "a3", ""); err != ErrNoDescriptors {
+		t.Errorf("sub-Filer over its limit err=%v, want ErrNoDescriptors", err)
+	}
+	b1, err := b.TempFile("", "b1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.TryTempFile("", "b2", ""); err != ErrNoDescriptors {
+		t.Errorf("sub-Filer over parent limit err=%v, want ErrNoDescriptors", err)
+	}
+	if s := filer.Stats(); s.Open != 3 {
+		t.Errorf("parent Stats().Open=%d, want 3", s.Open)
+	}
+	if s := a.Stats(); s.Open != 2 || s.Limit != 2 {
+		t.Errorf("a.Stats()=%+v, want Open=2, Limit=2", s)
+	}
+	if s := b.Stats(); s.Open != 1 || s.TotalOpens != 1 {
+		t.Errorf("b.Stats()=%+v, want Open=1, TotalOpens=1", s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.Shutdown(ctx); err != context.Canceled {
+		t.Errorf("a.Shutdown err=%v, want context.Canceled", err)
+	}
+	if err := underlyingError(a1.Close()); err != os.ErrClosed {
+		t.Errorf("a1.Close()=%v, want os.ErrClosed", err)
+	}
+	a2.Close()
+	if _, err := b1.Write([]byte("b1")); err != nil {
+		t.Errorf("b1 unusable after shutdown of a: %v", err)
+	}
+	if s := filer.Stats(); s.Open != 1 {
+		t.Errorf("after a.Shutdown parent Stats().Open=%d, want 1", s.Open)
+	}
+
+	if err := filer.Shutdown(ctx); err != context.Canceled {
+		t.Errorf("filer.Shutdown err=%v, want context.Canceled", err)
+	}
+	if err := underlyingError(b1.Close()); err != os.ErrClosed {
+		t.Errorf("b1.Close()=%v after parent shutdown, want os.ErrClosed", err)
+	}
+	if s := filer.Stats(); s.Open != 0 {
+		t.Errorf("after Shutdown parent Stats().Open=%d, want 0", s.Open)
+	}
+}
+
+func TestFilerFIFO(t *testing.T) {
+	filer := NewFiler(1)
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	waiting := 0
+	open := func(name string, ctx context.Context) {
+		waiting++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := filer.TempFileContext(ctx, "", name, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			f.Close()
+		}()
+		for filer.Stats().Waiting != waiting {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	open("w1", context.Background())
+	open("w2", context.Background())
+	open("w3", context.Background())
+	open("urgent", WithPriority(context.Background(), 1))
+	open("w4", context.Background())
+	open("urgent2", WithPriority(context.Background(), 1))
+
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if got, want := strings.Join(order, ","), "urgent,urgent2,w1,w2,w3,w4"; got != want {
+		t.Errorf("descriptors granted in order %s, want %s", got, want)
+	}
+}
+
 func openAndCloseTempFile(filer *Filer) error {
 	f, err := filer.TempFile("", "temp-file-opened-and-closed", "")
 	if f != nil {
@@ -234,3 +573,175 @@ func TestFileNilClose(t *testing.T) {
 		t.Errorf("f.Close()=%v, want os.ErrInvalid", err)
 	}
 }
+
+func TestFilerLeakCheck(t *testing.T) {
+	filer := NewFiler(1)
+	filer.LeakCheck = true
+	filer.ReclaimLeaks = true
+	reports := make(chan string, 2)
+	filer.OnLeak = func(report string) { reports <- report }
+
+	var name string
+	func() {
+		bf := filer.BufferFile(1)
+		if _, err := bf.Write([]byte("spill to disk")); err != nil {
+			t.Fatal(err)
+		}
+		name = bf.f.Name()
+	}()
+
+	var report string
+	for i := 0; report == "" && i < 100; i++ {
+		runtime.GC()
+		select {
+		case report = <-reports:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if report == "" {
+		t.Fatal("no leak reported")
+	}
+	if !strings.Contains(report, "bufferfile") || !strings.Contains(report, "TestFilerLeakCheck") {
+		t.Errorf("leak report does not name the bufferfile creator: %s", report)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("leaked temp file not removed: %v", err)
+	}
+	f, err := filer.TryTempFile("", "testfile", "")
+	if err != nil {
+		t.Fatalf("slot not reclaimed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+	select {
+	case report := <-reports:
+		t.Errorf("closed file reported as leaked: %s", report)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestFilerShutdownUnclosed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	filer := NewFiler(1)
+	filer.Logf = func(format string, v ...interface{}) {
+		fmt.Fprintf(buf, format+"\n", v...)
+	}
+	func() {
+		if _, err := filer.TempFile("", "testfile", ""); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	runtime.GC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := filer.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown err=%v, want context.DeadlineExceeded", err)
+	}
+	if log := buf.String(); !strings.Contains(log, "waiting for file created by") {
+		t.Errorf("Shutdown did not report the unclosed file:\n%s", log)
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Shutdown, want 0", open)
+	}
+
+	// With LeakCheck the File is collected, and Shutdown reclaims it.
+	filer = NewFiler(1)
+	filer.LeakCheck = true
+	filer.OnLeak = func(string) {}
+	func() {
+		if _, err := filer.TempFile("", "testfile", ""); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := filer.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown with collected file err=%v", err)
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Shutdown, want 0", open)
+	}
+}
+
+func TestFilerStackDepth(t *testing.T) {
+	buf := new(bytes.Buffer)
+	filer := NewFiler(2)
+	filer.Logf = func(format string, v ...interface{}) {
+		fmt.Fprintf(buf, format, v...)
+		buf.WriteByte('\n')
+	}
+	filer.StackDepth = 32
+
+	f, err := openATempFile(filer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	bf := openBufferFile2(filer)
+	defer bf.Close()
+	if _, err := bf.Write([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []*File{f, bf.f} {
+		stack := file.stack()
+		if len(stack) < 2 || !strings.Contains(stack[1], "TestFilerStackDepth") {
+			t.Errorf("%s stack does not reach the test:\n%s", file.Name(), strings.Join(stack, "\n"))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := filer.Shutdown(ctx); err != context.Canceled {
+		t.Fatalf("Shutdown err=%v, want context.Canceled", err)
+	}
+	log := buf.String()
+	for _, want := range []string{"crawshaw.io/iox.openATempFile", "crawshaw.io/iox.openBufferFile2", "crawshaw.io/iox.TestFilerStackDepth"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("Shutdown log does not contain %q:\n%s", want, log)
+		}
+	}
+}
+
+func TestFilerTempName(t *testing.T) {
+	dir := t.TempDir()
+	filer := NewFiler(2)
+	filer.SetTempdir(dir)
+
+	f1, err := filer.TempFile("", "testfile-", ".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	want := regexp.MustCompile(fmt.Sprintf(`^testfile-\.iox-%d-[0-9a-f]{16}\.tmp$`, os.Getpid()))
+	if name := filepath.Base(f1.Name()); !want.MatchString(name) {
+		t.Errorf("temp file name %q does not match %v", name, want)
+	}
+	f1.Close()
+
+	names := []string{"a", "a", "b"}
+	filer.TempName = func() string {
+		name := names[0]
+		names = names[1:]
+		return name
+	}
+	f1, err = filer.TempFile("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := filer.TempFile("", "", "") // collides with "a", retries
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	if got, want := filepath.Base(f2.Name()), fmt.Sprintf(".iox-%d-b", os.Getpid()); got != want {
+		t.Errorf("temp file name %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS returns a file system for the tree of files rooted at the
+// directory root, like os.DirFS, whose files are opened by the Filer.
+//
+// The fs.File values it returns are *File. They count against the
+// limit of the Filer until closed. The file system also implements
+// fs.ReadFileFS, fs.ReadDirFS, and fs.StatFS.
+func (f *Filer) FS(root string) fs.FS {
+	return filerFS{f: f, root: root}
+}
+
+type filerFS struct {
+	f    *Filer
+	root string
+}
+
+func (fsys filerFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(fsys.root, filepath.FromSlash(name)), nil
+}
+
+// relErr reports err, from an operation on the fs.FS path name,
+// with the path name instead of the OS path.
+func relErr(err error, name string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: pathErr.Op, Path: name, Err: pathErr.Err}
+	}
+	return err
+}
+
+func (fsys filerFS) Open(name string) (fs.File, error) {
+	path, err := fsys.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.f.openFile(context.Background(), true, path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, relErr(err, name)
+	}
+	fsys.f.setCreator(&file.origin)
+	return file, nil
+}
+
+func (fsys filerFS) ReadFile(name string) ([]byte, error) {
+	path, err := fsys.join("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fsys.f.openFile(context.Background(), true, path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, relErr(err, name)
+	}
+	fsys.f.setCreator(&file.origin)
+	defer file.Close()
+
+	var size int64
+	if fi, err := file.Stat(); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	}
+	b := make([]byte, 0, size+512)
+	for {
+		n, err := file.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return b, relErr(err, name)
+		}
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+	}
+}
+
+func (fsys filerFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	path, err := fsys.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fsys.f.ReadDir(path)
+	return entries, relErr(err, name)
+}
+
+func (fsys filerFS) Stat(name string) (fs.FileInfo, error) {
+	path, err := fsys.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	return fi, relErr(err, name)
+}
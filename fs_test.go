@@ -0,0 +1,67 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFilerFS(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a/b"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"1.txt": "one", "a/2.txt": "two", "a/b/3.txt": "three"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filer := NewFiler(16) // fstest.TestFS holds several files open
+	fsys := filer.FS(root)
+	if err := fstest.TestFS(fsys, "1.txt", "a/2.txt", "a/b/3.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fsys.Open("a/2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(*File); !ok {
+		t.Errorf("Open returned %T, want *File", f)
+	}
+	if open := filer.Stats().Open; open != 1 {
+		t.Errorf("Stats().Open=%d with one fs.File open, want 1", open)
+	}
+	f.Close()
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Close, want 0", open)
+	}
+
+	if b, err := fs.ReadFile(fsys, "a/b/3.txt"); err != nil || string(b) != "three" {
+		t.Errorf("ReadFile=%q, %v, want three", b, err)
+	}
+	if _, err := fsys.Open("../escape"); err == nil {
+		t.Error("Open of invalid path succeeded")
+	}
+	_, err = fsys.Open("missing")
+	if pathErr, ok := err.(*fs.PathError); !ok || pathErr.Path != "missing" {
+		t.Errorf("Open missing err=%v, want *fs.PathError for missing", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// Dial connects to the address on the named network.
+//
+// It is similar to net.Dial except it will block if Filer has exhausted
+// its file descriptors until one is available.
+func (f *Filer) Dial(network, address string) (*Conn, error) {
+	c, err := f.dial(context.Background(), network, address)
+	if c != nil {
+		f.setCreator(&c.origin)
+	}
+	return c, err
+}
+
+// DialContext is like Dial, but gives up waiting for a file descriptor
+// or the connection when ctx is done.
+func (f *Filer) DialContext(ctx context.Context, network, address string) (*Conn, error) {
+	c, err := f.dial(ctx, network, address)
+	if c != nil {
+		f.setCreator(&c.origin)
+	}
+	return c, err
+}
+
+func (f *Filer) dial(ctx context.Context, network, address string) (*Conn, error) {
+	var d net.Dialer
+	for {
+		if err := f.acquire(ctx, true); err != nil {
+			return nil, err
+		}
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			if isTooManyFiles(err) && f.osLimited() {
+				continue
+			}
+			f.release()
+			return nil, err
+		}
+		return f.newConn(conn), nil
+	}
+}
+
+// Listen announces on the local network address.
+//
+// It is similar to net.Listen except the listening socket and every
+// connection it accepts hold a file descriptor from the Filer.
+func (f *Filer) Listen(network, address string) (*Listener, error) {
+	for {
+		if err := f.acquire(context.Background(), true); err != nil {
+			return nil, err
+		}
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			if isTooManyFiles(err) && f.osLimited() {
+				continue
+			}
+			f.release()
+			return nil, err
+		}
+		l := &Listener{Listener: ln}
+		l.ctx, l.cancel = context.WithCancel(context.Background())
+		f.newSock(&l.sock, "listener", ln.Addr().String(), ln)
+		f.setCreator(&l.origin)
+		return l, nil
+	}
+}
+
+// sock is a network file descriptor managed by a Filer.
+type sock struct {
+	origin
+
+	filer  *Filer
+	kind   string // "conn" or "listener"
+	name   string
+	closer io.Closer
+}
+
+// newSock registers s, opened with a descriptor reserved by acquire.
+func (f *Filer) newSock(s *sock, kind, name string, closer io.Closer) {
+	s.filer = f
+	s.kind = kind
+	s.name = name
+	s.closer = closer
+	s.created = time.Now()
+
+	f.mu.Lock()
+	f.socks[s] = struct{}{}
+	f.mu.Unlock()
+	f.opened()
+}
+
+func (f *Filer) newConn(conn net.Conn) *Conn {
+	c := &Conn{Conn: conn}
+	f.newSock(&c.sock, "conn", conn.LocalAddr().String()+"->"+conn.RemoteAddr().String(), conn)
+	return c
+}
+
+func (s *sock) remove() {
+	f := s.filer
+	f.mu.Lock()
+	if _, ok := f.socks[s]; ok {
+		delete(f.socks, s)
+		f.releaseLocked()
+	}
+	f.mu.Unlock()
+}
+
+// Conn is a net.Conn managed by a Filer.
+//
+// The Close method must be called on a Conn.
+type Conn struct {
+	net.Conn
+	sock
+}
+
+// Close closes the connection and informs the Filer.
+func (c *Conn) Close() error {
+	if c == nil || c.Conn == nil {
+		return net.ErrClosed
+	}
+	err := c.Conn.Close()
+	c.sock.remove()
+	return err
+}
+
+// Listener is a net.Listener managed by a Filer.
+//
+// Accept reserves a file descriptor from the Filer before waiting for
+// a connection, blocking if the Filer has exhausted its descriptors.
+// Accepted connections are of type *Conn.
+type Listener struct {
+	net.Listener
+	sock
+
+	ctx    context.Context // done when the Listener is closed
+	cancel func()
+}
+
+// Accept waits for a file descriptor from the Filer and for the
+// next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	f := l.filer
+	for {
+		if err := f.acquire(l.ctx, true); err != nil {
+			if l.ctx.Err() != nil {
+				return nil, net.ErrClosed
+			}
+			return nil, err
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if isTooManyFiles(err) && f.osLimited() {
+				continue
+			}
+			f.release()
+			return nil, err
+		}
+		c := f.newConn(conn)
+		f.setCreator(&c.origin)
+		return c, nil
+	}
+}
+
+// Close stops the listener and informs the Filer.
+// Connections already accepted are not closed.
+func (l *Listener) Close() error {
+	l.cancel()
+	err := l.Listener.Close()
+	l.sock.remove()
+	return err
+}
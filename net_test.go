@@ -0,0 +1,89 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilerNet(t *testing.T) {
+	filer := NewFiler(3)
+	ln, err := filer.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acceptCh := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(acceptCh)
+				return
+			}
+			acceptCh <- c
+		}
+	}()
+
+	c1, err := filer.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := <-acceptCh
+	if _, ok := s1.(*Conn); !ok {
+		t.Errorf("Accept returned %T, want *iox.Conn", s1)
+	}
+	if s := filer.Stats(); s.Open != 3 {
+		t.Errorf("Stats().Open=%d, want listener and two connections", s.Open)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := filer.DialContext(ctx, "tcp", ln.Addr().String()); err != context.DeadlineExceeded {
+		t.Errorf("DialContext with no descriptors err=%v, want context.DeadlineExceeded", err)
+	}
+
+	report := filer.debugReport(false)
+	var kinds []string
+	for _, file := range report.Files {
+		kinds = append(kinds, file.Kind)
+		if file.Kind == "conn" && !strings.HasSuffix(file.Creator, "iox.TestFilerNet") && !strings.HasSuffix(file.Creator, "iox.TestFilerNet.func1") {
+			t.Errorf("conn %s created by %s, want TestFilerNet", file.Name, file.Creator)
+		}
+	}
+	if got, want := strings.Join(kinds, ","), "listener,conn,conn"; got != want {
+		t.Errorf("debug report kinds %s, want %s", got, want)
+	}
+
+	if err := c1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-acceptCh; ok {
+		t.Error("Accept succeeded after Close")
+	}
+	if s := filer.Stats(); s.Open != 0 {
+		t.Errorf("after Close Stats().Open=%d, want 0", s.Open)
+	}
+}
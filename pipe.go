@@ -0,0 +1,69 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"os"
+)
+
+// Pipe returns a connected pair of Files, like os.Pipe.
+// Reads from r return bytes written to w.
+//
+// The two file descriptors are reserved from the Filer at once, so
+// Pipe cannot deadlock with goroutines holding one descriptor while
+// waiting for another. It blocks until both are available. Each File
+// releases its descriptor when closed.
+func (f *Filer) Pipe() (r, w *File, err error) {
+	r, w, err = f.pipe(context.Background(), true)
+	if err == nil {
+		f.setCreator(&r.origin)
+		f.setCreator(&w.origin)
+	}
+	return r, w, err
+}
+
+// PipeContext is like Pipe, but gives up waiting for file descriptors
+// when ctx is done, returning ctx.Err().
+func (f *Filer) PipeContext(ctx context.Context) (r, w *File, err error) {
+	r, w, err = f.pipe(ctx, true)
+	if err == nil {
+		f.setCreator(&r.origin)
+		f.setCreator(&w.origin)
+	}
+	return r, w, err
+}
+
+func (f *Filer) pipe(ctx context.Context, wait bool) (r, w *File, err error) {
+	for {
+		if err := f.acquireN(ctx, wait, 2); err != nil {
+			return nil, nil, err
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			f.release()
+			if isTooManyFiles(err) && f.osLimited() {
+				continue // wait for a descriptor to close and try again
+			}
+			f.release()
+			return nil, nil, err
+		}
+		r = &File{File: pr, filer: f, pipe: true}
+		w = &File{File: pw, filer: f, pipe: true}
+		f.addFile(r)
+		f.addFile(w)
+		return r, w, nil
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFilerPipe(t *testing.T) {
+	filer := NewFiler(2)
+	f, err := filer.TempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type pipe struct {
+		r, w *File
+		err  error
+	}
+	done := make(chan pipe)
+	go func() {
+		r, w, err := filer.Pipe()
+		done <- pipe{r, w, err}
+	}()
+	select {
+	case <-done:
+		t.Fatal("Pipe did not wait for two descriptors")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if open := filer.Stats().Open; open != 1 {
+		t.Errorf("Stats().Open=%d while Pipe waits, want 1", open)
+	}
+	f.Close()
+	p := <-done
+	if p.err != nil {
+		t.Fatal(p.err)
+	}
+
+	report := filer.debugReport(false)
+	if len(report.Files) != 2 || report.Files[0].Kind != "pipe" || report.Files[1].Kind != "pipe" {
+		t.Errorf("debug report lists %+v, want two pipes", report.Files)
+	}
+	for _, file := range report.Files {
+		if file.Creator != "crawshaw.io/iox.TestFilerPipe.func1" {
+			t.Errorf("pipe creator %q, want TestFilerPipe.func1", file.Creator)
+		}
+	}
+
+	go func() {
+		io.WriteString(p.w, "hello")
+		p.w.Close()
+	}()
+	b, err := io.ReadAll(p.r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("read %q from pipe, want hello", b)
+	}
+	if err := p.r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after closing pipe, want 0", open)
+	}
+}
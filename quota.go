@@ -0,0 +1,161 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTempQuota is reported by writes to temporary files that would
+// exceed Filer.TempQuota.
+var ErrTempQuota = errors.New("iox: temporary file quota exceeded")
+
+// quota reports whether the size of file is charged to Filer.TempQuota
+// of its Filer or one of its parents.
+func (file *File) quota() bool {
+	if !file.isTemp || file.atomicFile {
+		return false
+	}
+	for f := file.filer; f != nil; f = f.parent {
+		if f.TempQuota > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// growTemp charges the growth of file to size bytes to the temporary
+// file quotas of its Filer and the Filer's parents, waiting for space
+// if Filer.TempQuotaWait is set.
+func (file *File) growTemp(size int64) error {
+	f := file.filer
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		if _, ok := f.files[file.key]; !ok {
+			return nil // closed, let the write report it
+		}
+		grow := size - file.tempSize
+		if grow <= 0 {
+			return nil
+		}
+		quota, released := f.chargeTempLocked(grow)
+		if released == nil {
+			file.tempSize = size
+			return nil
+		}
+		if !f.TempQuotaWait || size > quota {
+			return ErrTempQuota
+		}
+
+		f.mu.Unlock()
+		select {
+		case <-released:
+		case <-f.shuttingDown:
+			f.mu.Lock()
+			return ErrTempQuota
+		}
+		f.mu.Lock()
+	}
+}
+
+// shrinkTemp returns the space beyond size bytes charged for file
+// to the Filer's temporary file quota.
+func (file *File) shrinkTemp(size int64) {
+	f := file.filer
+	f.mu.Lock()
+	if size < file.tempSize {
+		f.dischargeTempLocked(file.tempSize - size)
+		file.tempSize = size
+	}
+	f.mu.Unlock()
+}
+
+// chargeTempLocked charges grow bytes to the temporary file quota of f
+// and its parents. If that would exceed a quota nothing is charged, and
+// it reports the quota and a channel closed when its Filer next releases
+// space. It is called with f.mu held.
+func (f *Filer) chargeTempLocked(grow int64) (quota int64, released chan struct{}) {
+	if f.TempQuota > 0 && f.tempBytes+grow > f.TempQuota {
+		return f.TempQuota, f.released
+	}
+	if f.parent != nil {
+		f.parent.mu.Lock()
+		quota, released = f.parent.chargeTempLocked(grow)
+		f.parent.mu.Unlock()
+		if released != nil {
+			return quota, released
+		}
+	}
+	f.tempBytes += grow
+	return 0, nil
+}
+
+// dischargeTempLocked returns n bytes to the temporary file quota of f
+// and its parents, waking writers waiting for space.
+// It is called with f.mu held.
+func (f *Filer) dischargeTempLocked(n int64) {
+	f.tempBytes -= n
+	f.wakeLocked()
+	if f.parent != nil {
+		f.parent.mu.Lock()
+		f.parent.dischargeTempLocked(n)
+		f.parent.mu.Unlock()
+	}
+}
+
+// writeTemp writes p to a temporary file, subject to Filer.TempQuota.
+func (file *File) writeTemp(p []byte) (n int, err error) {
+	if file.quota() {
+		off, err := file.File.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		if err := file.growTemp(off + int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	n, err = file.File.Write(p)
+	file.tempFailed(err)
+	return n, err
+}
+
+// writeAtTemp writes p to a temporary file at off, subject to
+// Filer.TempQuota.
+func (file *File) writeAtTemp(p []byte, off int64) (n int, err error) {
+	if file.quota() {
+		if err := file.growTemp(off + int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	n, err = file.File.WriteAt(p, off)
+	file.tempFailed(err)
+	return n, err
+}
+
+// tempFailed reports a failed write to the health tracking of the
+// temporary directory holding file.
+func (file *File) tempFailed(err error) {
+	if err != nil && file.tempdir != nil {
+		file.tempdir.fail(err)
+	}
+}
+
+// writerOnly hides all methods but Write, such as a ReadFrom that would
+// call io.Copy recursively.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilerTempQuota(t *testing.T) {
+	filer := NewFiler(3)
+	filer.TempQuota = 10
+
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := filer.TempFile("", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	if _, err := f1.Write(make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2.Write(make([]byte, 6)); err != ErrTempQuota {
+		t.Errorf("Write over quota err=%v, want ErrTempQuota", err)
+	}
+	if _, err := f2.ReadFrom(bytes.NewReader(make([]byte, 6))); err != ErrTempQuota {
+		t.Errorf("ReadFrom over quota err=%v, want ErrTempQuota", err)
+	}
+	if used := filer.Stats().TempBytes; used != 6 {
+		t.Errorf("TempBytes=%d, want 6", used)
+	}
+	if err := f1.Truncate(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2.WriteAt(make([]byte, 6), 0); err != nil {
+		t.Fatal(err)
+	}
+	if stats := filer.Stats(); stats.TempBytes != 8 || stats.TempQuota != 10 {
+		t.Errorf("TempBytes=%d, TempQuota=%d, want 8, 10", stats.TempBytes, stats.TempQuota)
+	}
+
+	bf := filer.BufferFile(1)
+	defer bf.Close()
+	if _, err := bf.Write(make([]byte, 4)); err != ErrTempQuota {
+		t.Errorf("BufferFile Write over quota err=%v, want ErrTempQuota", err)
+	}
+
+	filer.TempQuotaWait = true
+	if _, err := f2.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := f2.Write(make([]byte, 3))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Write over quota did not wait, err=%v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if used := filer.Stats().TempBytes; used != 9 {
+		t.Errorf("TempBytes=%d, want 9", used)
+	}
+}
+
+func TestFilerTempQuotaSub(t *testing.T) {
+	filer := NewFiler(3)
+	filer.TempQuota = 10
+	sub := filer.Sub(2)
+
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := sub.TempFile("", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	if _, err := f1.Write(make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2.Write(make([]byte, 6)); err != ErrTempQuota {
+		t.Errorf("sub-Filer Write over parent quota err=%v, want ErrTempQuota", err)
+	}
+	if _, err := f2.Write(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f2, "!"); err != ErrTempQuota {
+		t.Errorf("WriteString over quota err=%v, want ErrTempQuota", err)
+	}
+	if used := filer.Stats().TempBytes; used != 10 {
+		t.Errorf("parent TempBytes=%d, want 10", used)
+	}
+	if used := sub.Stats().TempBytes; used != 4 {
+		t.Errorf("sub-Filer TempBytes=%d, want 4", used)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if used := filer.Stats().TempBytes; used != 6 {
+		t.Errorf("parent TempBytes=%d after close, want 6", used)
+	}
+}
+
+func TestFilerTempQuotaAtomic(t *testing.T) {
+	filer := NewFiler(1)
+	filer.TempQuota = 10
+
+	af, err := filer.CreateAtomic(filepath.Join(t.TempDir(), "file"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := af.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("AtomicFile Write err=%v, want it not charged to the quota", err)
+	}
+	if used := filer.Stats().TempBytes; used != 0 {
+		t.Errorf("TempBytes=%d, want 0", used)
+	}
+	if err := af.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
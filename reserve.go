@@ -0,0 +1,139 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// A Reservation holds file descriptors of a Filer reserved by Reserve,
+// to be used by its Open, OpenFile, and TempFile methods.
+//
+// When a File opened from a Reservation is closed, its descriptor is
+// returned to the Filer, not to the Reservation.
+type Reservation struct {
+	filer *Filer
+
+	mu       sync.Mutex
+	n        int // descriptors not yet used
+	released bool
+}
+
+// Reserve reserves n file descriptors at once, blocking until they
+// are available or ctx is done.
+//
+// Acquiring descriptors one at a time can deadlock when goroutines each
+// hold some while waiting for more, so operations that need several
+// files at once, such as merging K files, should reserve them first.
+// The descriptors must be used or handed back with Release.
+//
+// Reserve reports ErrTooManyDescriptors if n is more than the limit
+// of the Filer, or the limit drops below n while waiting.
+func (f *Filer) Reserve(ctx context.Context, n int) (*Reservation, error) {
+	if n <= 0 {
+		panic("iox.Filer.Reserve: n must be positive")
+	}
+	if err := f.acquireN(ctx, true, n); err != nil {
+		return nil, err
+	}
+	return &Reservation{filer: f, n: n}, nil
+}
+
+type reservationKey struct{}
+
+// reservation reports the Reservation of f carried by ctx, if any.
+func (f *Filer) reservation(ctx context.Context) *Reservation {
+	r, _ := ctx.Value(reservationKey{}).(*Reservation)
+	if r == nil || r.filer != f {
+		return nil
+	}
+	return r
+}
+
+// take uses n of the reserved descriptors, reporting ErrNoDescriptors
+// if too few remain.
+func (r *Reservation) take(n int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n < n {
+		return ErrNoDescriptors
+	}
+	r.n -= n
+	return nil
+}
+
+// giveBack returns n descriptors taken but not used.
+func (r *Reservation) giveBack(n int) {
+	r.mu.Lock()
+	released := r.released
+	if !released {
+		r.n += n
+	}
+	r.mu.Unlock()
+	if released {
+		for i := 0; i < n; i++ {
+			r.filer.release()
+		}
+	}
+}
+
+func (r *Reservation) ctx() context.Context {
+	return context.WithValue(context.Background(), reservationKey{}, r)
+}
+
+// Open opens the named file for reading with a reserved descriptor,
+// see Filer.Open. It reports ErrNoDescriptors if none remain.
+func (r *Reservation) Open(name string) (*File, error) {
+	file, err := r.filer.openFile(r.ctx(), true, name, os.O_RDONLY, 0)
+	if file != nil {
+		r.filer.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// OpenFile opens the named file with a reserved descriptor,
+// see Filer.OpenFile. It reports ErrNoDescriptors if none remain.
+func (r *Reservation) OpenFile(name string, flag int, perm os.FileMode) (*File, error) {
+	file, err := r.filer.openFile(r.ctx(), true, name, flag, perm)
+	if file != nil {
+		r.filer.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// TempFile creates a new temporary file with a reserved descriptor,
+// see Filer.TempFile. It reports ErrNoDescriptors if none remain.
+func (r *Reservation) TempFile(dir, prefix, suffix string) (*File, error) {
+	file, err := r.filer.tempFile(r.ctx(), true, dir, prefix, suffix, 0600)
+	if file != nil {
+		r.filer.setCreator(&file.origin)
+	}
+	return file, err
+}
+
+// Release hands the unused descriptors of the Reservation back to the
+// Filer. The Reservation cannot be used after Release.
+func (r *Reservation) Release() {
+	r.mu.Lock()
+	n := r.n
+	r.n = 0
+	r.released = true
+	r.mu.Unlock()
+	for i := 0; i < n; i++ {
+		r.filer.release()
+	}
+}
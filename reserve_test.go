@@ -0,0 +1,160 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilerReserve(t *testing.T) {
+	filer := NewFiler(3)
+	ctx := context.Background()
+
+	r, err := filer.Reserve(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := filer.TryTempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filer.TryTempFile("", "testfile", ""); err != ErrNoDescriptors {
+		t.Errorf("TryTempFile beyond reservation err=%v, want ErrNoDescriptors", err)
+	}
+
+	if _, err := r.Open(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("Open of missing file err=%v, want os.IsNotExist", err)
+	}
+	r1, err := r.TempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := r.OpenFile(f.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.TempFile("", "testfile", ""); err != ErrNoDescriptors {
+		t.Errorf("TempFile from used reservation err=%v, want ErrNoDescriptors", err)
+	}
+	if open := filer.Stats().Open; open != 3 {
+		t.Errorf("Stats().Open=%d, want 3", open)
+	}
+	r.Release()
+	r1.Close()
+	r2.Close()
+
+	if _, err := filer.Reserve(ctx, 4); err != ErrTooManyDescriptors {
+		t.Errorf("Reserve of more than the limit err=%v, want ErrTooManyDescriptors", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := filer.Reserve(tctx, 3); err != context.DeadlineExceeded {
+		t.Errorf("Reserve with a descriptor held err=%v, want context.DeadlineExceeded", err)
+	}
+
+	r, err = filer.Reserve(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if open := filer.Stats().Open; open != 2 {
+		t.Errorf("Stats().Open=%d with unused reservation, want 2", open)
+	}
+	r.Release()
+	r.Release()
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d after Release, want 0", open)
+	}
+}
+
+func TestFilerReserveStats(t *testing.T) {
+	filer := NewFiler(3)
+	f, err := filer.TempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f.Close()
+	}()
+	start := time.Now()
+	r, err := filer.Reserve(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waited := time.Since(start)
+	r.Release()
+
+	s := filer.Stats()
+	if s.TotalOpens != 4 {
+		t.Errorf("Stats().TotalOpens=%d, want 4", s.TotalOpens)
+	}
+	if s.TotalWait > waited {
+		t.Errorf("Stats().TotalWait=%v, want the Reserve wait of at most %v counted once", s.TotalWait, waited)
+	}
+	var opens int64
+	for _, n := range s.WaitHistogram {
+		opens += n
+	}
+	if opens != 2 {
+		t.Errorf("Stats().WaitHistogram=%v, want 2 opens", s.WaitHistogram)
+	}
+}
+
+func TestFilerReserveLimitDrop(t *testing.T) {
+	filer := NewFiler(4)
+	ctx := context.Background()
+
+	f1, err := filer.TempFile("", "testfile1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := filer.TempFile("", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := filer.Reserve(ctx, 3)
+		done <- err
+	}()
+	for filer.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	filer.SetLimit(2)
+	if err := <-done; err != ErrTooManyDescriptors {
+		t.Errorf("Reserve beyond lowered limit err=%v, want ErrTooManyDescriptors", err)
+	}
+	f1.Close()
+	f2.Close()
+
+	tctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	f, err := filer.TempFileContext(tctx, "", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if stats := filer.Stats(); stats.Open != 0 || stats.Waiting != 0 {
+		t.Errorf("Open=%d, Waiting=%d, want 0, 0", stats.Open, stats.Waiting)
+	}
+}
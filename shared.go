@@ -0,0 +1,153 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// sharedFD is a read-only descriptor shared by Files, see ShareReadOnly.
+type sharedFD struct {
+	name string
+	file *os.File
+	fi   os.FileInfo // of file when opened
+	refs int         // Files using file, guarded by Filer.mu
+}
+
+// unchanged reports whether fi describes the same file as the earlier
+// old, without modifications. Inode numbers are reused, so a file
+// replaced by another can pass os.SameFile.
+func unchanged(fi, old os.FileInfo) bool {
+	return os.SameFile(fi, old) && fi.Size() == old.Size() && fi.ModTime().Equal(old.ModTime())
+}
+
+// openShared opens name for reading with a descriptor shared with other
+// Files. It reports a nil File and error if name cannot be shared.
+func (f *Filer) openShared(ctx context.Context, wait bool, name string) (*File, error) {
+	fi, err := os.Stat(name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	f.mu.Lock()
+	select {
+	case <-f.shuttingDown:
+		f.mu.Unlock()
+		return nil, context.Canceled
+	case <-ctx.Done():
+		f.mu.Unlock()
+		return nil, ctx.Err()
+	default:
+	}
+	if sd := f.shared[name]; sd != nil && unchanged(fi, sd.fi) {
+		sd.refs++
+		file := &File{
+			File:   sd.file,
+			filer:  f,
+			shared: sd,
+		}
+		file.created = time.Now()
+		f.trackLocked(file)
+		f.mu.Unlock()
+		return file, nil
+	}
+	f.mu.Unlock()
+
+	osfile, err := f.openOS(ctx, wait, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{
+		File:  osfile,
+		filer: f,
+	}
+	if fi, err := osfile.Stat(); err == nil && fi.Mode().IsRegular() {
+		file.shared = &sharedFD{
+			name: name,
+			file: osfile,
+			fi:   fi,
+			refs: 1,
+		}
+		f.mu.Lock()
+		f.shared[name] = file.shared // replaces any stale descriptor
+		f.mu.Unlock()
+	}
+	f.addFile(file)
+	return file, nil
+}
+
+// useShared prepares a shared file for an operation.
+// On success file.vmu is held.
+func (file *File) useShared() error {
+	file.vmu.Lock()
+	if file.closed {
+		file.vmu.Unlock()
+		return &os.PathError{Op: "read", Path: file.File.Name(), Err: os.ErrClosed}
+	}
+	return nil
+}
+
+func (file *File) readShared(p []byte) (n int, err error) {
+	if err := file.useShared(); err != nil {
+		return 0, err
+	}
+	defer file.vmu.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err = file.File.ReadAt(p, file.off)
+	file.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil // like read(2), report io.EOF on the next call
+	}
+	return n, err
+}
+
+func (file *File) seekShared(offset int64, whence int) (int64, error) {
+	if err := file.useShared(); err != nil {
+		return 0, err
+	}
+	defer file.vmu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.off
+	case io.SeekEnd:
+		fi, err := file.File.Stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += fi.Size()
+	default:
+		offset = -1
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: file.File.Name(), Err: syscall.EINVAL}
+	}
+	file.off = offset
+	return offset, nil
+}
+
+// readerOnly hides all methods but Read, such as a WriteTo that would
+// call io.Copy recursively.
+type readerOnly struct {
+	io.Reader
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFilerShareReadOnly(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "iox-shared-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := tmp.Name()
+	defer os.Remove(name)
+	if _, err := tmp.WriteString("hello, world"); err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	filer := NewFiler(1)
+	filer.ShareReadOnly = true
+
+	f1, err := filer.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := filer.TryOpen(name)
+	if err != nil {
+		t.Fatalf("second Open of shared file: %v", err)
+	}
+	if s := filer.Stats(); s.Open != 1 {
+		t.Errorf("Stats().Open=%d, want 1 shared descriptor", s.Open)
+	}
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(f1, b); err != nil || string(b) != "hello" {
+		t.Errorf("f1 Read=%q, %v, want hello", b, err)
+	}
+	if _, err := f2.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f2, b); err != nil || string(b) != "world" {
+		t.Errorf("f2 Read=%q, %v, want world", b, err)
+	}
+	if _, err := f2.Read(b); err != io.EOF {
+		t.Errorf("f2 Read at end err=%v, want io.EOF", err)
+	}
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f1.Read(b); underlyingError(err) != os.ErrClosed {
+		t.Errorf("Read after Close err=%v, want os.ErrClosed", err)
+	}
+	if err := underlyingError(f1.Close()); err != os.ErrClosed {
+		t.Errorf("second Close err=%v, want os.ErrClosed", err)
+	}
+
+	// f2 keeps working after f1 closes.
+	rest, err := ioutil.ReadAll(io.NewSectionReader(f2, 7, 5))
+	if err != nil || string(rest) != "world" {
+		t.Errorf("f2 ReadAt=%q, %v, want world", rest, err)
+	}
+
+	// A modified file gets a new descriptor.
+	if err := ioutil.WriteFile(name, []byte("goodbye"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filer.TryOpen(name); err != ErrNoDescriptors {
+		t.Errorf("TryOpen of modified file err=%v, want ErrNoDescriptors", err)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := filer.Stats(); s.Open != 0 {
+		t.Errorf("after Close Stats().Open=%d, want 0", s.Open)
+	}
+	f3, err := filer.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(f3); err != nil || string(got) != "goodbye" {
+		t.Errorf("f3 contents %q, %v, want goodbye", got, err)
+	}
+
+	// The descriptor of f3 is not shared once Shutdown starts.
+	done := make(chan error)
+	go func() { done <- filer.Shutdown(context.Background()) }()
+	<-filer.shuttingDown
+	if f4, err := filer.Open(name); err != context.Canceled {
+		t.Errorf("Open of shared file during Shutdown err=%v, want context.Canceled", err)
+		if f4 != nil {
+			f4.Close()
+		}
+	}
+	f3.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import "time"
+
+// WaitBuckets are the upper bounds of the FilerStats.WaitHistogram buckets.
+// The final histogram bucket counts waits longer than the last bound.
+var WaitBuckets = [...]time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// FilerStats is a snapshot of the file descriptor use of a Filer.
+type FilerStats struct {
+	Open     int // descriptors currently open, including sockets
+	Limit    int // maximum number of files open at once, see OSLimited
+	Waiting  int // goroutines waiting for a file descriptor
+	PeakOpen int // maximum value Open has reached
+	Parked   int // virtual files with their descriptor closed, see IdleTimeout
+
+	TempBytes int64 // size of temporary files, see Filer.TempQuota
+	TempQuota int64
+
+	TotalOpens int64         // file descriptors handed out
+	TotalWait  time.Duration // time spent waiting for file descriptors
+
+	// OSLimited counts opens that failed with EMFILE or ENFILE because
+	// the process ran out of descriptors before the Filer did.
+	// Such opens wait for a Filer file to close and try again,
+	// and Limit is lowered until opens succeed again.
+	OSLimited int64
+
+	// WaitHistogram counts opens by how long they waited for a
+	// file descriptor, counting descriptors acquired at once, such as
+	// by Reserve, as one open. WaitHistogram[i] counts waits no longer than
+	// WaitBuckets[i], the final element counts the remainder.
+	WaitHistogram [len(WaitBuckets) + 1]int64
+}
+
+// Stats reports the current file descriptor use of the Filer.
+func (f *Filer) Stats() FilerStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := f.stats
+	stats.Open = f.used
+	stats.Limit = f.limitLocked()
+	stats.TempBytes = f.tempBytes
+	stats.TempQuota = f.TempQuota
+	return stats
+}
+
+// opened records the handing out of n file descriptors at once,
+// after waiting for wait. It is called with f.mu held.
+func (s *FilerStats) opened(open, n int, wait time.Duration) {
+	if open > s.PeakOpen {
+		s.PeakOpen = open
+	}
+	s.TotalOpens += int64(n)
+	s.TotalWait += wait
+
+	i := 0
+	for i < len(WaitBuckets) && wait > WaitBuckets[i] {
+		i++
+	}
+	s.WaitHistogram[i]++
+}
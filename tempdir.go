@@ -0,0 +1,106 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// TempdirPolicy selects the directory for each temporary file of a
+// Filer with several temporary directories, see SetTempdirs.
+type TempdirPolicy int
+
+const (
+	TempdirRoundRobin TempdirPolicy = iota // use each directory in turn
+	TempdirMostFree                        // use the directory with the most free space
+)
+
+// tempdirRetry is how long a failed temporary directory is skipped.
+const tempdirRetry = 30 * time.Second
+
+// tempdir is a directory used for temporary files.
+type tempdir struct {
+	path   string
+	failed atomic.Int64 // UnixNano of last failure, or zero
+}
+
+func (td *tempdir) healthy(now time.Time) bool {
+	failed := td.failed.Load()
+	return failed == 0 || now.Sub(time.Unix(0, failed)) > tempdirRetry
+}
+
+// fail marks the directory unhealthy if err reports that it is full,
+// failing, or missing.
+func (td *tempdir) fail(err error) {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT, syscall.EIO, syscall.EROFS, syscall.ENOENT, syscall.ENOTDIR, syscall.EACCES} {
+		if errors.Is(err, errno) {
+			td.failed.Store(time.Now().UnixNano())
+			return
+		}
+	}
+}
+
+// SetTempdirs sets the directories used to hold temporary files,
+// including the backing files of BufferFiles. Each temporary file
+// is placed in one of them according to f.TempdirPolicy.
+//
+// A directory in which creating or writing a temporary file fails,
+// for example because the disk is full, is skipped for a while.
+func (f *Filer) SetTempdirs(dirs ...string) {
+	if len(dirs) == 0 {
+		panic("iox.Filer.SetTempdirs: no directories")
+	}
+	tempdirs := make([]*tempdir, len(dirs))
+	for i, dir := range dirs {
+		tempdirs[i] = &tempdir{path: dir}
+	}
+	f.tempdirs = tempdirs
+}
+
+// pickTempdirs orders the temporary directories to try for a new
+// temporary file: the healthy directories by f.TempdirPolicy, followed
+// by the unhealthy ones in case they have recovered.
+func (f *Filer) pickTempdirs() []*tempdir {
+	tempdirs := f.tempdirs
+	if len(tempdirs) == 1 {
+		return tempdirs
+	}
+	now := time.Now()
+	start := int(f.tempNext.Add(1) % uint32(len(tempdirs)))
+	healthy := make([]*tempdir, 0, len(tempdirs))
+	var unhealthy []*tempdir
+	for i := range tempdirs {
+		td := tempdirs[(start+i)%len(tempdirs)]
+		if td.healthy(now) {
+			healthy = append(healthy, td)
+		} else {
+			unhealthy = append(unhealthy, td)
+		}
+	}
+	if f.TempdirPolicy == TempdirMostFree && len(healthy) > 1 {
+		free := make(map[*tempdir]uint64, len(healthy))
+		for _, td := range healthy {
+			free[td] = td.free()
+		}
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return free[healthy[i]] > free[healthy[j]]
+		})
+	}
+	return append(healthy, unhealthy...)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilerTempdirs(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir(), filepath.Join(t.TempDir(), "missing")}
+	filer := NewFiler(10)
+	filer.SetTempdirs(dirs...)
+
+	for i := 0; i < 4; i++ {
+		f, err := filer.TempFile("", "testfile", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+	}
+	for _, dir := range dirs[:2] {
+		if entries, err := os.ReadDir(dir); err != nil {
+			t.Fatal(err)
+		} else if len(entries) != 2 {
+			t.Errorf("%s holds %d temp files, want 2", dir, len(entries))
+		}
+	}
+	if filer.tempdirs[2].healthy(time.Now()) {
+		t.Error("missing tempdir is healthy")
+	}
+
+	filer.TempdirPolicy = TempdirMostFree
+	f, err := filer.TempFile("", "testfile", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir := filepath.Dir(f.Name()); dir != dirs[0] && dir != dirs[1] {
+		t.Errorf("TempdirMostFree placed file in %s", dir)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
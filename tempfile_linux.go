@@ -0,0 +1,84 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package iox
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	oTmpfile        = 0x400000 | syscall.O_DIRECTORY // O_TMPFILE
+	atFDCWD         = -0x64
+	atSymlinkFollow = 0x400
+)
+
+// openTmpfile opens an unnamed file in dir with O_TMPFILE, reporting
+// errors.ErrUnsupported if the kernel or file system does not support it.
+// The returned *os.File reports name as its Name.
+func openTmpfile(dir, name string, perm os.FileMode) (*os.File, error) {
+	fd, err := syscall.Open(dir, syscall.O_RDWR|syscall.O_CLOEXEC|oTmpfile, uint32(perm.Perm()))
+	for err == syscall.EINTR {
+		fd, err = syscall.Open(dir, syscall.O_RDWR|syscall.O_CLOEXEC|oTmpfile, uint32(perm.Perm()))
+	}
+	switch err {
+	case nil:
+		return os.NewFile(uintptr(fd), name), nil
+	case syscall.EISDIR, syscall.EOPNOTSUPP, syscall.EINVAL:
+		// Kernels before 3.11 treat O_TMPFILE as O_DIRECTORY,
+		// and not all file systems support it.
+		return nil, &os.PathError{Op: "open", Path: dir, Err: errors.ErrUnsupported}
+	}
+	return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+}
+
+// linkFD gives the file open as osfile the name name, using linkat
+// on its /proc/self/fd entry.
+func linkFD(osfile *os.File, name string) error {
+	conn, err := osfile.SyscallConn()
+	if err != nil {
+		return err
+	}
+	newpath, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var linkErr error
+	err = conn.Control(func(fd uintptr) {
+		oldpath, err := syscall.BytePtrFromString("/proc/self/fd/" + strconv.Itoa(int(fd)))
+		if err != nil {
+			linkErr = err
+			return
+		}
+		dirfd := atFDCWD
+		_, _, errno := syscall.Syscall6(syscall.SYS_LINKAT,
+			uintptr(dirfd), uintptr(unsafe.Pointer(oldpath)),
+			uintptr(dirfd), uintptr(unsafe.Pointer(newpath)),
+			atSymlinkFollow, 0)
+		if errno != 0 {
+			linkErr = errno
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return linkErr
+}
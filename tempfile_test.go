@@ -0,0 +1,68 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilerAnonymousTemp(t *testing.T) {
+	dir := t.TempDir()
+	filer := NewFiler(2)
+	filer.SetTempdir(dir)
+	filer.AnonymousTemp = true
+
+	f, err := filer.TempFile("", "anon-", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !f.anonymous {
+		t.Skip("anonymous temp files not supported")
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	bf := filer.BufferFile(1)
+	defer bf.Close()
+	if _, err := bf.Write([]byte("spilled")); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("tempdir holds %d entries, want none", len(entries))
+	}
+
+	name := filepath.Join(dir, "materialized")
+	if err := f.Materialize(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(name); err != nil || string(b) != "hello" {
+		t.Errorf("materialized file=%q, %v, want hello", b, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("tempdir holds %d entries, want only the materialized file", len(entries))
+	}
+	if open := filer.Stats().Open; open != 0 {
+		t.Errorf("Stats().Open=%d, want 0", open)
+	}
+}
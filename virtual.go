@@ -0,0 +1,360 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// This file implements virtual Files, whose descriptors are closed
+// while idle, and shared Files, whose descriptors are shared with
+// other Files. See Filer.IdleTimeout and Filer.ShareReadOnly.
+//
+// A virtual File holds file.vmu while in use, the Filer only closes
+// its descriptor if it can acquire file.vmu without waiting.
+
+// ErrReplaced is reported when a virtual File is reopened but its name
+// now refers to a different file.
+var ErrReplaced = errors.New("iox: virtual file replaced while idle")
+
+// use prepares a virtual file for an operation, reopening it if its
+// descriptor was closed while idle. On success file.vmu is held and
+// the operation must finish by calling file.unuse.
+func (file *File) use() error {
+	file.vmu.Lock()
+	if file.parked {
+		if err := file.reopen(); err != nil {
+			file.vmu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+func (file *File) unuse() {
+	file.lastUse.Store(time.Now().UnixNano())
+	file.vmu.Unlock()
+}
+
+// reopen reopens a parked virtual file. It is called with file.vmu held.
+//
+// The file may have been written to while parked, as by another File
+// appending to a log, so only a replaced file is rejected. Inode numbers
+// are reused, so a replacement can go unnoticed.
+func (file *File) reopen() error {
+	f := file.filer
+	name := file.File.Name()
+	flag := file.flag &^ (os.O_CREATE | os.O_EXCL | os.O_TRUNC)
+	osfile, err := f.openOS(context.Background(), true, name, flag, 0)
+	if err != nil {
+		return err
+	}
+	fi, err := osfile.Stat()
+	if err == nil && !os.SameFile(fi, file.fi) {
+		err = &os.PathError{Op: "open", Path: name, Err: ErrReplaced}
+	}
+	if err == nil && file.flag&os.O_APPEND == 0 {
+		_, err = osfile.Seek(file.off, io.SeekStart)
+	}
+
+	f.mu.Lock()
+	if _, ok := f.files[file.key]; !ok && err == nil {
+		// Closed by Shutdown while parked.
+		err = &os.PathError{Op: "open", Path: name, Err: os.ErrClosed}
+	}
+	if err == nil {
+		file.File = osfile
+		file.parked = false
+		f.stats.Parked--
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		osfile.Close()
+		f.release()
+		return err
+	}
+	f.opened()
+	return nil
+}
+
+// evictForLocked evicts idle virtual files of f and its sub-Filers
+// until n more descriptors fit in the limit of f, or none are left.
+// It is called with f.mu held, which it releases while evicting.
+func (f *Filer) evictForLocked(n int) {
+	for f.used+n > f.limitLocked() {
+		f.mu.Unlock()
+		evicted := f.evict()
+		f.mu.Lock()
+		if !evicted {
+			return
+		}
+	}
+}
+
+// evict closes the descriptor of the least recently used virtual file
+// of f or its sub-Filers that has been idle for IdleTimeout, reporting
+// whether it released a descriptor. The descriptor of a file of a
+// sub-Filer is also released from f. It is called without f.mu held.
+func (f *Filer) evict() bool {
+	victim, use := f.idlest(time.Now())
+	if victim == nil {
+		return false
+	}
+	vf := victim.filer
+	vf.mu.Lock()
+	defer vf.mu.Unlock()
+	return vf.parkLocked(victim, use)
+}
+
+// idlest reports the least recently used virtual file of f and its
+// sub-Filers that has been idle for the IdleTimeout of its Filer,
+// and when it was last used.
+func (f *Filer) idlest(now time.Time) (victim *File, victimUse int64) {
+	f.mu.Lock()
+	if f.IdleTimeout > 0 {
+		idleSince := now.Add(-f.IdleTimeout).UnixNano()
+		for key := range f.files {
+			file := key.value()
+			if file == nil || !file.virtual || file.parked {
+				continue
+			}
+			if use := file.lastUse.Load(); use <= idleSince && (victim == nil || use < victimUse) {
+				victim, victimUse = file, use
+			}
+		}
+	}
+	subs := make([]*Filer, 0, len(f.subs))
+	for sub := range f.subs {
+		subs = append(subs, sub)
+	}
+	f.mu.Unlock()
+
+	for _, sub := range subs {
+		if file, use := sub.idlest(now); file != nil && (victim == nil || use < victimUse) {
+			victim, victimUse = file, use
+		}
+	}
+	return victim, victimUse
+}
+
+// parkLocked closes the descriptor of the virtual file victim, found
+// idle since use by idlest, unless it was closed or used since.
+// It is called with f.mu held.
+func (f *Filer) parkLocked(victim *File, use int64) bool {
+	if _, ok := f.files[victim.key]; !ok || victim.parked || victim.lastUse.Load() != use {
+		return false
+	}
+	if !victim.vmu.TryLock() {
+		return false
+	}
+	defer victim.vmu.Unlock()
+
+	off, err := victim.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false
+	}
+	fi, err := victim.File.Stat()
+	if err != nil {
+		return false
+	}
+	victim.off = off
+	victim.fi = fi
+	victim.File.Close()
+	victim.parked = true
+	f.stats.Parked++
+	f.releaseLocked()
+	return true
+}
+
+// Read reads up to len(p) bytes from the File, see os.File.Read.
+func (file *File) Read(p []byte) (n int, err error) {
+	if file.shared != nil {
+		return file.readShared(p)
+	}
+	if !file.virtual {
+		return file.File.Read(p)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.Read(p)
+}
+
+// ReadAt reads len(p) bytes from the File at offset off,
+// see os.File.ReadAt.
+func (file *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if file.shared != nil {
+		if err := file.useShared(); err != nil {
+			return 0, err
+		}
+		defer file.vmu.Unlock()
+		return file.File.ReadAt(p, off)
+	}
+	if !file.virtual {
+		return file.File.ReadAt(p, off)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.ReadAt(p, off)
+}
+
+// Write writes len(p) bytes to the File, see os.File.Write.
+func (file *File) Write(p []byte) (n int, err error) {
+	if file.isTemp {
+		return file.writeTemp(p)
+	}
+	if !file.virtual {
+		return file.File.Write(p)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.Write(p)
+}
+
+// WriteString is like Write, but writes the contents of string s.
+func (file *File) WriteString(s string) (n int, err error) {
+	return file.Write([]byte(s))
+}
+
+// WriteAt writes len(p) bytes to the File at offset off,
+// see os.File.WriteAt.
+func (file *File) WriteAt(p []byte, off int64) (n int, err error) {
+	if file.isTemp {
+		return file.writeAtTemp(p, off)
+	}
+	if !file.virtual {
+		return file.File.WriteAt(p, off)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.WriteAt(p, off)
+}
+
+// ReadFrom implements io.ReaderFrom, see os.File.ReadFrom.
+func (file *File) ReadFrom(r io.Reader) (n int64, err error) {
+	if file.quota() {
+		return io.Copy(writerOnly{file}, r)
+	}
+	if file.isTemp {
+		n, err := file.File.ReadFrom(r)
+		file.tempFailed(err)
+		return n, err
+	}
+	if !file.virtual {
+		return file.File.ReadFrom(r)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.ReadFrom(r)
+}
+
+// WriteTo implements io.WriterTo, see os.File.WriteTo.
+func (file *File) WriteTo(w io.Writer) (n int64, err error) {
+	if file.shared != nil {
+		return io.Copy(w, readerOnly{file})
+	}
+	if !file.virtual {
+		return file.File.WriteTo(w)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.WriteTo(w)
+}
+
+// Seek sets the offset for the next Read or Write on the File,
+// see os.File.Seek.
+func (file *File) Seek(offset int64, whence int) (ret int64, err error) {
+	if file.shared != nil {
+		return file.seekShared(offset, whence)
+	}
+	if !file.virtual {
+		return file.File.Seek(offset, whence)
+	}
+	if err := file.use(); err != nil {
+		return 0, err
+	}
+	defer file.unuse()
+	return file.File.Seek(offset, whence)
+}
+
+// Stat returns the os.FileInfo describing the File, see os.File.Stat.
+func (file *File) Stat() (os.FileInfo, error) {
+	if file.shared != nil {
+		if err := file.useShared(); err != nil {
+			return nil, err
+		}
+		defer file.vmu.Unlock()
+		return file.File.Stat()
+	}
+	if !file.virtual {
+		return file.File.Stat()
+	}
+	if err := file.use(); err != nil {
+		return nil, err
+	}
+	defer file.unuse()
+	return file.File.Stat()
+}
+
+// Sync commits the contents of the File to stable storage,
+// see os.File.Sync.
+func (file *File) Sync() error {
+	if !file.virtual {
+		return file.File.Sync()
+	}
+	if err := file.use(); err != nil {
+		return err
+	}
+	defer file.unuse()
+	return file.File.Sync()
+}
+
+// Truncate changes the size of the File, see os.File.Truncate.
+func (file *File) Truncate(size int64) error {
+	if file.quota() {
+		if err := file.growTemp(size); err != nil {
+			return err
+		}
+		err := file.File.Truncate(size)
+		if err == nil {
+			file.shrinkTemp(size)
+		}
+		return err
+	}
+	if !file.virtual {
+		return file.File.Truncate(size)
+	}
+	if err := file.use(); err != nil {
+		return err
+	}
+	defer file.unuse()
+	return file.File.Truncate(size)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package iox
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilerVirtual(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "iox-virtual-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := tmp.Name()
+	tmp.Close()
+	defer os.Remove(name)
+
+	filer := NewFiler(1)
+	filer.IdleTimeout = 10 * time.Millisecond
+
+	f1, err := filer.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f1.Write([]byte("hello, world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f1.Seek(7, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+
+	// f1 is busy, so f2 waits until it has been idle for IdleTimeout.
+	start := time.Now()
+	f2, err := filer.TempFile("", "testfile2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < filer.IdleTimeout {
+		t.Errorf("TempFile took %v, want it to wait for f1 to be idle", d)
+	}
+	if s := filer.Stats(); s.Open != 1 || s.Parked != 1 {
+		t.Errorf("Stats()=%+v, want Open=1, Parked=1", s)
+	}
+
+	// Reading f1 reopens it, waiting for f2 to close.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f2.Close()
+	}()
+	b := make([]byte, 5)
+	if _, err := f1.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "world" {
+		t.Errorf("Read after reopen got %q, want %q", b, "world")
+	}
+	if s := filer.Stats(); s.Open != 1 || s.Parked != 0 {
+		t.Errorf("Stats()=%+v, want Open=1, Parked=0", s)
+	}
+
+	// Writing a string reopens a parked file too.
+	time.Sleep(2 * filer.IdleTimeout)
+	f4, err := filer.TempFile("", "testfile4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f4.Close()
+	if _, err := io.WriteString(f1, "!"); err != nil {
+		t.Fatalf("WriteString on parked file: %v", err)
+	}
+
+	// A file appended to while parked is reopened.
+	time.Sleep(2 * filer.IdleTimeout)
+	f5, err := filer.TempFile("", "testfile5", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f5.Close()
+	w, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("?"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if n, err := f1.Read(b); err != nil || string(b[:n]) != "?" {
+		t.Errorf("Read of appended file got %q, %v, want %q", b[:n], err, "?")
+	}
+
+	// A file replaced while parked is not silently reopened.
+	// The replacement is renamed into place so it has another inode.
+	time.Sleep(2 * filer.IdleTimeout)
+	f3, err := filer.TempFile("", "testfile3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f3.Close()
+	if err := ioutil.WriteFile(name+".new", []byte("replaced"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(name+".new", name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f1.Read(b); underlyingError(err) != ErrReplaced {
+		t.Errorf("Read of replaced file err=%v, want ErrReplaced", err)
+	}
+
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := underlyingError(f1.Close()); err != os.ErrClosed {
+		t.Errorf("second Close err=%v, want os.ErrClosed", err)
+	}
+	if s := filer.Stats(); s.Open != 0 || s.Parked != 0 {
+		t.Errorf("after Close Stats()=%+v, want Open=0, Parked=0", s)
+	}
+}
+
+func TestFilerVirtualSub(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(name, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	filer := NewFiler(1)
+	filer.IdleTimeout = 10 * time.Millisecond
+	a, b := filer.Sub(1), filer.Sub(1)
+
+	f1, err := a.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+
+	// The idle file of sibling a is evicted for b.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	f2, err := b.OpenContext(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := a.Stats(); s.Open != 0 || s.Parked != 1 {
+		t.Errorf("sub-Filer Stats()=%+v, want Open=0, Parked=1", s)
+	}
+	f2.Close()
+
+	b1 := make([]byte, 5)
+	if _, err := f1.Read(b1); err != nil || string(b1) != "hello" {
+		t.Errorf("Read after reopen got %q, %v, want hello", b1, err)
+	}
+}